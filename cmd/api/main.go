@@ -1,16 +1,21 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/JamesHsu333/go-grpc/config"
 	"github.com/JamesHsu333/go-grpc/internal/server"
+	"github.com/JamesHsu333/go-grpc/migrations"
 	"github.com/JamesHsu333/go-grpc/pkg/database/postgres"
 	"github.com/JamesHsu333/go-grpc/pkg/database/redis"
 	"github.com/JamesHsu333/go-grpc/pkg/logger"
 	"github.com/JamesHsu333/go-grpc/pkg/utils"
 	"github.com/JamesHsu333/go-grpc/pkg/version"
+	"github.com/jmoiron/sqlx"
 	"github.com/opentracing/opentracing-go"
 	jaegerlog "github.com/uber/jaeger-client-go/log"
 	"github.com/uber/jaeger-lib/metrics"
@@ -48,6 +53,19 @@ func main() {
 	}
 	defer psqlDB.Close()
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err = migrate(psqlDB, appLogger, os.Args[2:]); err != nil {
+			appLogger.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+
+	if cfg.Postgres.AutoMigrate {
+		if err = migrate(psqlDB, appLogger, []string{"up"}); err != nil {
+			appLogger.Fatalf("migrate: %v", err)
+		}
+	}
+
 	redisClient := redis.NewRedisClient(cfg)
 	defer redisClient.Close()
 	appLogger.Info("Redis connected")
@@ -82,3 +100,49 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// Run migrate subcommand: up [steps], down [steps|all] or version; up applies all pending and down reverts one by default
+func migrate(db *sqlx.DB, appLogger logger.Logger, args []string) error {
+	migrator, err := postgres.NewMigrator(db, migrations.FS, appLogger)
+	if err != nil {
+		return err
+	}
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	steps := 0
+	if command == "down" {
+		steps = 1
+	}
+	all := false
+	if len(args) > 1 {
+		if command == "down" && args[1] == "all" {
+			all = true
+		} else if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+			return fmt.Errorf("invalid steps %q, expected a positive number", args[1])
+		}
+	}
+
+	ctx := context.Background()
+	switch command {
+	case "up":
+		return migrator.Up(ctx, steps)
+	case "down":
+		if all {
+			return migrator.DownAll(ctx)
+		}
+		return migrator.Down(ctx, steps)
+	case "version":
+		version, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		appLogger.Infof("Migration version: %d", version)
+		return nil
+	}
+
+	return fmt.Errorf("unknown command %q, expected up, down or version", command)
+}
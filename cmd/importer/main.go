@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	userProto "github.com/JamesHsu333/go-grpc/proto/user"
+)
+
+// Import users from csv or json lines file with ImportUsers rpc and write per row report as csv
+func main() {
+	addr := flag.String("addr", "localhost:5001", "user service address")
+	token := flag.String("token", os.Getenv("IMPORTER_TOKEN"), "admin access token, defaults to IMPORTER_TOKEN env")
+	file := flag.String("file", "", "csv or json lines file to import")
+	format := flag.String("format", "", "file format, csv or jsonl, detected from file extension by default")
+	reportPath := flag.String("report", "", "report file, stdout by default")
+	dryRun := flag.Bool("dry-run", false, "validate rows without importing")
+	chunkSize := flag.Int("chunk", 500, "rows per streamed message")
+	timeout := flag.Duration("timeout", 30*time.Minute, "import timeout")
+	flag.Parse()
+
+	if *file == "" {
+		log.Fatal("file is required")
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*file)), ".")
+	}
+
+	source, err := os.Open(*file)
+	if err != nil {
+		log.Fatalf("os.Open: %v", err)
+	}
+	defer source.Close()
+
+	rows, failed, err := readRows(source, *format)
+	if err != nil {
+		log.Fatalf("readRows: %v", err)
+	}
+	log.Printf("Read %d rows, %d rows can not be parsed", len(rows), len(failed))
+
+	conn, err := grpc.Dial(*addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("grpc.Dial: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+*token)
+
+	res, err := importRows(ctx, userProto.NewUserServiceClient(conn), rows, *dryRun, *chunkSize)
+	if err != nil {
+		log.Fatalf("importRows: %v", err)
+	}
+	log.Printf("Imported: %d, failed: %d, dry run: %v", res.GetImported(), res.GetFailed()+int32(len(failed)), res.GetDryRun())
+
+	report := io.Writer(os.Stdout)
+	if *reportPath != "" {
+		reportFile, err := os.Create(*reportPath)
+		if err != nil {
+			log.Fatalf("os.Create: %v", err)
+		}
+		defer reportFile.Close()
+		report = reportFile
+	}
+
+	if err = writeReport(report, append(failed, res.GetResults()...)); err != nil {
+		log.Fatalf("writeReport: %v", err)
+	}
+}
+
+// Stream rows in chunks, dry run flag is sent with the first chunk
+func importRows(ctx context.Context, client userProto.UserServiceClient, rows []*userProto.ImportUserRow, dryRun bool, chunkSize int) (*userProto.ImportUsersResponse, error) {
+	stream, err := client.ImportUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if chunkSize <= 0 {
+		chunkSize = len(rows)
+	}
+	for start := 0; start == 0 || start < len(rows); start += chunkSize {
+		end := start + chunkSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		if err = stream.Send(&userProto.ImportUsersRequest{DryRun: dryRun, Rows: rows[start:end]}); err != nil {
+			return nil, err
+		}
+	}
+
+	return stream.CloseAndRecv()
+}
+
+// Write report of results ordered by line
+func writeReport(w io.Writer, results []*userProto.ImportUserResult) error {
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].GetLine() < results[j].GetLine()
+	})
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"line", "email", "status", "user_id", "error"}); err != nil {
+		return err
+	}
+	for _, result := range results {
+		status := "failed"
+		if result.GetSuccess() {
+			status = "ok"
+		}
+		if err := writer.Write([]string{
+			strconv.FormatInt(result.GetLine(), 10),
+			result.GetEmail(),
+			status,
+			result.GetUserId(),
+			result.GetError(),
+		}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+
+	return writer.Error()
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	userProto "github.com/JamesHsu333/go-grpc/proto/user"
+)
+
+// Source record of user, csv header uses the same names as json keys
+type record struct {
+	Email       string `json:"email"`
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	Password    string `json:"password"`
+	Role        string `json:"role"`
+	About       string `json:"about"`
+	PhoneNumber string `json:"phone_number"`
+	Address     string `json:"address"`
+	City        string `json:"city"`
+	Country     string `json:"country"`
+	Gender      string `json:"gender"`
+	Postcode    string `json:"postcode"`
+	Birthday    string `json:"birthday"`
+}
+
+// Read rows of source, rows that can not be parsed are returned as failed results
+func readRows(r io.Reader, format string) ([]*userProto.ImportUserRow, []*userProto.ImportUserResult, error) {
+	switch format {
+	case "csv":
+		return readCSV(r)
+	case "jsonl":
+		return readJSONL(r)
+	}
+	return nil, nil, fmt.Errorf("unknown format %q", format)
+}
+
+func readCSV(r io.Reader) ([]*userProto.ImportUserRow, []*userProto.ImportUserResult, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("csv header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := recordFields[name]; !ok {
+			return nil, nil, fmt.Errorf("csv header: unknown column %q", name)
+		}
+		columns[name] = i
+	}
+
+	var rows []*userProto.ImportUserRow
+	var failed []*userProto.ImportUserResult
+	for {
+		values, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, nil, fmt.Errorf("csv: %w", err)
+			}
+			failed = append(failed, &userProto.ImportUserResult{Line: int64(parseErr.StartLine), Error: err.Error()})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+
+		rec := &record{}
+		for name, i := range columns {
+			if i < len(values) {
+				*recordFields[name](rec) = strings.TrimSpace(values[i])
+			}
+		}
+
+		row, err := rec.toProto(int64(line))
+		if err != nil {
+			failed = append(failed, &userProto.ImportUserResult{Line: int64(line), Email: rec.Email, Error: err.Error()})
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, failed, nil
+}
+
+func readJSONL(r io.Reader) ([]*userProto.ImportUserRow, []*userProto.ImportUserResult, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []*userProto.ImportUserRow
+	var failed []*userProto.ImportUserResult
+	for line := int64(1); scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		rec := &jsonRecord{}
+		if err := json.Unmarshal([]byte(text), rec); err != nil {
+			failed = append(failed, &userProto.ImportUserResult{Line: line, Error: err.Error()})
+			continue
+		}
+		rec.record.Postcode = rec.Postcode.String()
+
+		row, err := rec.record.toProto(line)
+		if err != nil {
+			failed = append(failed, &userProto.ImportUserResult{Line: line, Email: rec.Email, Error: err.Error()})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return rows, failed, nil
+}
+
+// Json lines record, postcode is either a number or a numeric string there
+type jsonRecord struct {
+	record
+	Postcode json.Number `json:"postcode"`
+}
+
+// Fields of record keyed by column name
+var recordFields = map[string]func(rec *record) *string{
+	"email":        func(rec *record) *string { return &rec.Email },
+	"first_name":   func(rec *record) *string { return &rec.FirstName },
+	"last_name":    func(rec *record) *string { return &rec.LastName },
+	"password":     func(rec *record) *string { return &rec.Password },
+	"role":         func(rec *record) *string { return &rec.Role },
+	"about":        func(rec *record) *string { return &rec.About },
+	"phone_number": func(rec *record) *string { return &rec.PhoneNumber },
+	"address":      func(rec *record) *string { return &rec.Address },
+	"city":         func(rec *record) *string { return &rec.City },
+	"country":      func(rec *record) *string { return &rec.Country },
+	"gender":       func(rec *record) *string { return &rec.Gender },
+	"postcode":     func(rec *record) *string { return &rec.Postcode },
+	"birthday":     func(rec *record) *string { return &rec.Birthday },
+}
+
+func (rec *record) toProto(line int64) (*userProto.ImportUserRow, error) {
+	row := &userProto.ImportUserRow{
+		Line:        line,
+		Email:       rec.Email,
+		FirstName:   rec.FirstName,
+		LastName:    rec.LastName,
+		Password:    rec.Password,
+		Role:        rec.Role,
+		About:       rec.About,
+		PhoneNumber: rec.PhoneNumber,
+		Address:     rec.Address,
+		City:        rec.City,
+		Country:     rec.Country,
+		Gender:      rec.Gender,
+	}
+
+	if rec.Postcode != "" {
+		postcode, err := strconv.ParseInt(rec.Postcode, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("postcode: %w", err)
+		}
+		row.Postcode = int32(postcode)
+	}
+
+	if rec.Birthday != "" {
+		birthday, err := time.Parse("2006-01-02", rec.Birthday)
+		if err != nil {
+			return nil, fmt.Errorf("birthday: %w", err)
+		}
+		row.Birthday = timestamppb.New(birthday)
+	}
+
+	return row, nil
+}
@@ -10,16 +10,26 @@ import (
 
 // App config struct
 type Config struct {
-	Server   ServerConfig
-	Postgres PostgresConfig
-	Redis    RedisConfig
-	Cookie   Cookie
-	Store    Store
-	Session  Session
-	Metrics  Metrics
-	Logger   Logger
-	File     File
-	Jaeger   Jaeger
+	Server     ServerConfig
+	Postgres   PostgresConfig
+	Redis      RedisConfig
+	Cookie     Cookie
+	Store      Store
+	Session    Session
+	Metrics    Metrics
+	Logger     Logger
+	File       File
+	Jaeger     Jaeger
+	RBAC       RBAC
+	Jwt        Jwt
+	Mail       Mail
+	Password   Password
+	Verify     Verify
+	Lockout    Lockout
+	MFA        MFA
+	SoftDelete SoftDelete
+	Outbox     Outbox
+	Watch      Watch
 }
 
 // Server config struct
@@ -39,6 +49,7 @@ type ServerConfig struct {
 	Timeout           time.Duration
 	MaxConnectionAge  time.Duration
 	Time              time.Duration
+	TrustedProxies    []string
 }
 
 // Logger config
@@ -59,6 +70,7 @@ type PostgresConfig struct {
 	PostgresqlDbname   string
 	PostgresqlSSLMode  bool
 	PgDriver           string
+	AutoMigrate        bool
 }
 
 // Redis config
@@ -82,11 +94,14 @@ type Cookie struct {
 	HTTPOnly bool
 }
 
-// Session config
+// Session config, Expire is idle timeout in seconds, session is extended on use
+// at most once per RefreshInterval and never lives longer than MaxLifetime
 type Session struct {
-	Prefix string
-	Name   string
-	Expire int
+	Prefix          string
+	Name            string
+	Expire          int
+	MaxLifetime     int
+	RefreshInterval int
 }
 
 // Metrics config
@@ -102,7 +117,9 @@ type Store struct {
 
 // File config
 type File struct {
-	FilePath string
+	FilePath            string
+	MaxAvatarSize       int64
+	AllowedContentTypes []string
 }
 
 // Jaeger
@@ -112,6 +129,102 @@ type Jaeger struct {
 	LogSpans    bool
 }
 
+// Mail config, Driver is either file or smtp
+type Mail struct {
+	Driver       string
+	From         string
+	OutboxPath   string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUser     string
+	SMTPPassword string
+}
+
+// Password config
+type Password struct {
+	ResetTokenExpire int
+}
+
+// Email verification config
+type Verify struct {
+	TokenExpire          int
+	RequireVerifiedEmail bool
+}
+
+// Soft deleted users retention config, Retention and PurgeInterval are in seconds
+type SoftDelete struct {
+	Retention     int
+	PurgeInterval int
+}
+
+// Domain events outbox relay config, Publisher is memory or redis. PollInterval, RetryDelay, MaxDelay,
+// Retention of published events and CleanupInterval are in seconds
+type Outbox struct {
+	Publisher       string
+	Stream          string
+	StreamMaxLen    int64
+	PollInterval    int
+	BatchSize       int
+	MaxAttempts     int
+	RetryDelay      int
+	MaxDelay        int
+	Retention       int
+	CleanupInterval int
+}
+
+// Users watch config, resume tokens older than Retention expire and changes older than it are deleted
+// every CleanupInterval. Retention, PollInterval and CleanupInterval are in seconds
+type Watch struct {
+	Retention       int
+	PollInterval    int
+	CleanupInterval int
+}
+
+// TOTP multi-factor authentication config, ChallengeExpire is in seconds
+type MFA struct {
+	Issuer          string
+	ChallengeExpire int
+	RecoveryCodes   int
+}
+
+// Login brute force protection config, AttemptsWindow and Duration are in seconds,
+// DelayStep and MaxDelay are in milliseconds
+type Lockout struct {
+	MaxEmailAttempts int
+	MaxIPAttempts    int
+	AttemptsWindow   int
+	Duration         int
+	DelayStep        int
+	MaxDelay         int
+}
+
+// Jwt config, tokens are signed with KeyID key and verified with any of Keys
+type Jwt struct {
+	KeyID              string
+	Keys               []JwtKey
+	AccessTokenExpire  int
+	RefreshTokenExpire int
+}
+
+// Jwt signing key
+type JwtKey struct {
+	ID     string
+	Secret string
+}
+
+// RBAC config
+type RBAC struct {
+	AdminRole string
+	Policies  []Policy
+}
+
+// Policy config, roles allowed to call gRPC full method
+type Policy struct {
+	Method string
+	Roles  []string
+	Owner  bool
+}
+
 // Load config file from given path
 func LoadConfig(filename string) (*viper.Viper, error) {
 	v := viper.New()
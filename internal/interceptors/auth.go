@@ -0,0 +1,160 @@
+package interceptors
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/JamesHsu333/go-grpc/pkg/grpc_errors"
+	"github.com/JamesHsu333/go-grpc/pkg/utils"
+)
+
+const (
+	sessionIDHeader     = "session_id"
+	authorizationHeader = "authorization"
+	bearerPrefix        = "Bearer "
+)
+
+// Methods that can be called without a session, every other method requires one
+var publicMethods = map[string]bool{
+	"/user.UserService/Register":             true,
+	"/user.UserService/Login":                true,
+	"/user.UserService/FindByName":           true,
+	"/user.UserService/GetUserByID":          true,
+	"/user.UserService/RefreshToken":         true,
+	"/user.UserService/RequestPasswordReset": true,
+	"/user.UserService/ConfirmPasswordReset": true,
+	"/user.UserService/VerifyEmail":          true,
+	"/user.UserService/ResendVerification":   true,
+	"/user.UserService/VerifyMFA":            true,
+	"/user.UserService/GetMe":                false,
+	"/user.UserService/GetUsers":             false,
+	"/user.UserService/BatchGetUsers":        false,
+	"/user.UserService/ExportUsers":          false,
+	"/user.UserService/ImportUsers":          false,
+	"/user.UserService/WatchUsers":           false,
+	"/user.UserService/Update":               false,
+	"/user.UserService/UpdateRole":           false,
+	"/user.UserService/Delete":               false,
+	"/user.UserService/RestoreUser":          false,
+	"/user.UserService/ListAuditEvents":      false,
+	"/user.UserService/Logout":               false,
+	"/user.UserService/UploadAvatar":         false,
+	"/user.UserService/ChangePassword":       false,
+	"/user.UserService/ListMySessions":       false,
+	"/user.UserService/RevokeSession":        false,
+	"/user.UserService/RevokeAllSessions":    false,
+	"/user.UserService/ListUserSessions":     false,
+	"/user.UserService/RevokeUserSessions":   false,
+	"/user.UserService/RefreshSession":       false,
+	"/user.UserService/UnlockAccount":        false,
+	"/user.UserService/EnrollTOTP":           false,
+	"/user.UserService/ConfirmTOTP":          false,
+	"/user.UserService/DisableTOTP":          false,
+	"/user.UserService/SearchUsers":          false,
+}
+
+// Auth Interceptor, resolves session from metadata and puts it into context
+func (im *InterceptorManager) Auth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if publicMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+
+	ctx, err := im.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// Stream Auth Interceptor, same as Auth for streaming methods
+func (im *InterceptorManager) StreamAuth(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if publicMethods[info.FullMethod] {
+		return handler(srv, ss)
+	}
+
+	ctx, err := im.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+
+	wrapped := grpc_middleware.WrapServerStream(ss)
+	wrapped.WrappedContext = ctx
+
+	return handler(srv, wrapped)
+}
+
+// Authenticate with bearer access token if present, otherwise with session id. Session of access token
+// must still exist, so logout and session revocation invalidate access tokens too
+func (im *InterceptorManager) authenticate(ctx context.Context) (context.Context, error) {
+	if token, ok := im.getBearerTokenFromCtx(ctx); ok {
+		claims, err := utils.ParseJWTToken(token, im.cfg)
+		if err != nil {
+			im.logger.Errorf("Auth.ParseJWTToken: %v", err)
+			return nil, status.Errorf(codes.Unauthenticated, "utils.ParseJWTToken: %v", grpc_errors.ErrInvalidToken)
+		}
+
+		userID, err := uuid.Parse(claims.UserID)
+		if err != nil {
+			im.logger.Errorf("Auth.uuid.Parse: %v", err)
+			return nil, status.Errorf(codes.Unauthenticated, "uuid.Parse: %v", grpc_errors.ErrInvalidToken)
+		}
+
+		sess, err := im.sessUC.Touch(ctx, claims.SessionID)
+		if err != nil || sess.UserID != userID {
+			im.logger.Errorf("Auth.sessUC.Touch: session %s of token: %v", claims.SessionID, err)
+			return nil, status.Errorf(codes.Unauthenticated, "sessUC.Touch: %v", grpc_errors.ErrInvalidToken)
+		}
+
+		return utils.ContextWithSession(ctx, sess), nil
+	}
+
+	sessID, err := im.getSessionIDFromCtx(ctx)
+	if err != nil {
+		im.logger.Errorf("Auth.getSessionIDFromCtx: %v", err)
+		return nil, status.Errorf(codes.Unauthenticated, "getSessionIDFromCtx: %v", err)
+	}
+
+	sess, err := im.sessUC.Touch(ctx, sessID)
+	if err != nil {
+		im.logger.Errorf("Auth.sessUC.Touch: %v", err)
+		return nil, status.Errorf(codes.Unauthenticated, "sessUC.Touch: %v", grpc_errors.ErrInvalidSessionId)
+	}
+
+	return utils.ContextWithSession(ctx, sess), nil
+}
+
+func (im *InterceptorManager) getSessionIDFromCtx(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", grpc_errors.ErrNoCtxMetaData
+	}
+
+	sessionID := md.Get(sessionIDHeader)
+	if len(sessionID) == 0 || sessionID[0] == "" {
+		return "", grpc_errors.ErrInvalidSessionId
+	}
+
+	return sessionID[0], nil
+}
+
+func (im *InterceptorManager) getBearerTokenFromCtx(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	authorization := md.Get(authorizationHeader)
+	if len(authorization) == 0 || !strings.HasPrefix(authorization[0], bearerPrefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(authorization[0], bearerPrefix), true
+}
@@ -9,21 +9,34 @@ import (
 	"google.golang.org/grpc/metadata"
 
 	"github.com/JamesHsu333/go-grpc/config"
+	"github.com/JamesHsu333/go-grpc/internal/session"
+	"github.com/JamesHsu333/go-grpc/internal/user"
 	"github.com/JamesHsu333/go-grpc/pkg/grpc_errors"
 	"github.com/JamesHsu333/go-grpc/pkg/logger"
 	"github.com/JamesHsu333/go-grpc/pkg/metric"
 )
 
+// Metadata keys carrying credentials, their values are never logged
+var redactedMetadata = map[string]bool{
+	authorizationHeader: true,
+	sessionIDHeader:     true,
+	"refresh_token":     true,
+	"cookie":            true,
+}
+
 // InterceptorManager
 type InterceptorManager struct {
-	logger logger.Logger
-	cfg    *config.Config
-	metr   metric.Metrics
+	logger   logger.Logger
+	cfg      *config.Config
+	metr     metric.Metrics
+	sessUC   session.UCSession
+	userUC   user.UseCase
+	policies map[string]config.Policy
 }
 
 // InterceptorManager constructor
-func NewInterceptorManager(logger logger.Logger, cfg *config.Config, metr metric.Metrics) *InterceptorManager {
-	return &InterceptorManager{logger: logger, cfg: cfg, metr: metr}
+func NewInterceptorManager(logger logger.Logger, cfg *config.Config, metr metric.Metrics, sessUC session.UCSession, userUC user.UseCase) *InterceptorManager {
+	return &InterceptorManager{logger: logger, cfg: cfg, metr: metr, sessUC: sessUC, userUC: userUC, policies: newPolicies(cfg, logger)}
 }
 
 // Logger Interceptor
@@ -31,11 +44,23 @@ func (im *InterceptorManager) Logger(ctx context.Context, req interface{}, info
 	start := time.Now()
 	md, _ := metadata.FromIncomingContext(ctx)
 	reply, err := handler(ctx, req)
-	im.logger.Infof("Method: %s, Time: %v, Metadata: %v, Err: %v", info.FullMethod, time.Since(start), md, err)
+	im.logger.Infof("Method: %s, Time: %v, Metadata: %v, Err: %v", info.FullMethod, time.Since(start), redactMetadata(md), err)
 
 	return reply, err
 }
 
+// Copy of metadata with credential values replaced
+func redactMetadata(md metadata.MD) metadata.MD {
+	redacted := make(metadata.MD, len(md))
+	for key, values := range md {
+		if redactedMetadata[key] {
+			values = []string{"[REDACTED]"}
+		}
+		redacted[key] = values
+	}
+	return redacted
+}
+
 func (im *InterceptorManager) Metrics(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 	start := time.Now()
 	resp, err := handler(ctx, req)
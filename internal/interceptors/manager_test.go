@@ -0,0 +1,30 @@
+package interceptors
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRedactMetadata(t *testing.T) {
+	md := metadata.Pairs(
+		"authorization", "Bearer token",
+		"session_id", "6f1c1a52-1f5e-4a57-9bd1-7d3f0a0c6a11",
+		"refresh_token", "family.secret",
+		"user-agent", "grpc-go",
+	)
+
+	want := metadata.Pairs(
+		"authorization", "[REDACTED]",
+		"session_id", "[REDACTED]",
+		"refresh_token", "[REDACTED]",
+		"user-agent", "grpc-go",
+	)
+	if got := redactMetadata(md); !reflect.DeepEqual(got, want) {
+		t.Errorf("redactMetadata() = %v, want %v", got, want)
+	}
+	if md.Get("authorization")[0] != "Bearer token" {
+		t.Errorf("redactMetadata() changed metadata = %v", md)
+	}
+}
@@ -0,0 +1,176 @@
+package interceptors
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/JamesHsu333/go-grpc/config"
+	"github.com/JamesHsu333/go-grpc/pkg/grpc_errors"
+	"github.com/JamesHsu333/go-grpc/pkg/logger"
+	"github.com/JamesHsu333/go-grpc/pkg/utils"
+	userProto "github.com/JamesHsu333/go-grpc/proto/user"
+)
+
+// Default policies of privileged methods, a config entry replaces the default of its method and a
+// missing or misspelled entry never opens a method to every authenticated user
+var defaultPolicies = map[string]config.Policy{
+	"/user.UserService/GetUsers":           {Roles: []string{"admin", "support"}},
+	"/user.UserService/ExportUsers":        {Roles: []string{"admin"}},
+	"/user.UserService/ImportUsers":        {Roles: []string{"admin"}},
+	"/user.UserService/WatchUsers":         {Roles: []string{"admin", "support"}},
+	"/user.UserService/Update":             {Roles: []string{"admin", "support"}, Owner: true},
+	"/user.UserService/UpdateRole":         {Roles: []string{"admin"}},
+	"/user.UserService/Delete":             {Roles: []string{"admin"}, Owner: true},
+	"/user.UserService/RestoreUser":        {Roles: []string{"admin"}},
+	"/user.UserService/ListAuditEvents":    {Roles: []string{"admin"}},
+	"/user.UserService/ListUserSessions":   {Roles: []string{"admin", "support"}},
+	"/user.UserService/RevokeUserSessions": {Roles: []string{"admin"}},
+	"/user.UserService/UnlockAccount":      {Roles: []string{"admin"}},
+	"/user.UserService/SearchUsers":        {Roles: []string{"admin"}},
+}
+
+// Request carrying target user id, e.g. DeleteRequest
+type userIDRequest interface {
+	GetUserId() string
+}
+
+// Request carrying target user, e.g. UpdateRequest
+type userRequest interface {
+	GetUser() *userProto.User
+}
+
+// Authorization Interceptor, must run after Auth
+func (im *InterceptorManager) Authorize(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := im.authorize(ctx, info.FullMethod, req); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// Stream Authorization Interceptor, only role policies apply as request is not known yet
+func (im *InterceptorManager) StreamAuthorize(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := im.authorize(ss.Context(), info.FullMethod, nil); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// Check authenticated user role against method policy, admin role is allowed to call everything
+func (im *InterceptorManager) authorize(ctx context.Context, method string, req interface{}) error {
+	policy, ok := im.policies[method]
+	if !ok {
+		return nil
+	}
+
+	sess, err := utils.GetSessionFromCtx(ctx)
+	if err != nil {
+		im.logger.Errorf("Authorize.GetSessionFromCtx: %v", err)
+		return status.Errorf(codes.Unauthenticated, "utils.GetSessionFromCtx: %v", err)
+	}
+
+	if policy.Owner && targetUserID(req) == sess.UserID.String() {
+		return nil
+	}
+
+	user, err := im.userUC.GetByID(ctx, sess.UserID)
+	if err != nil {
+		im.logger.Errorf("Authorize.userUC.GetByID: %v", err)
+		return status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.GetByID: %v", err)
+	}
+
+	role := user.GetRole()
+	if role == im.cfg.RBAC.AdminRole {
+		return nil
+	}
+	if hasRole(policy.Roles, role) {
+		return im.authorizeTarget(ctx, method, sess.UserID.String(), targetUserID(req))
+	}
+
+	im.logger.Warnf("Authorize: user %s with role %q denied access to %s", sess.UserID, role, method)
+	return status.Errorf(codes.PermissionDenied, "Authorize: %v", grpc_errors.ErrPermissionDenied)
+}
+
+// Only admins may act on admin accounts, e.g. support must not change an admin email
+func (im *InterceptorManager) authorizeTarget(ctx context.Context, method string, callerID string, targetID string) error {
+	if targetID == "" || targetID == callerID {
+		return nil
+	}
+
+	userID, err := uuid.Parse(targetID)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "uuid.Parse: %v", err)
+	}
+
+	target, err := im.userUC.GetByID(ctx, userID)
+	if err != nil {
+		if grpc_errors.ParseGRPCErrStatusCode(err) == codes.NotFound {
+			return nil
+		}
+		im.logger.Errorf("Authorize.userUC.GetByID: %v", err)
+		return status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.GetByID: %v", err)
+	}
+	if target.GetRole() != im.cfg.RBAC.AdminRole {
+		return nil
+	}
+
+	im.logger.Warnf("Authorize: user %s denied access to %s of admin %s", callerID, method, targetID)
+	return status.Errorf(codes.PermissionDenied, "Authorize: %v", grpc_errors.ErrPermissionDenied)
+}
+
+// Policies of config merged with defaults, config entries override defaults of their methods
+func newPolicies(cfg *config.Config, logger logger.Logger) map[string]config.Policy {
+	policies := make(map[string]config.Policy, len(defaultPolicies)+len(cfg.RBAC.Policies))
+	for method, p := range defaultPolicies {
+		p.Method = method
+		policies[method] = p
+	}
+
+	for _, p := range cfg.RBAC.Policies {
+		if _, ok := publicMethods[p.Method]; !ok {
+			logger.Warnf("RBAC: policy of unknown method %s", p.Method)
+		}
+
+		if defaults, ok := defaultPolicies[p.Method]; ok && !samePolicy(defaults, p) {
+			logger.Warnf("RBAC: policy of %s overridden by config, roles %v owner %v, default roles %v owner %v",
+				p.Method, p.Roles, p.Owner, defaults.Roles, defaults.Owner)
+		}
+		policies[p.Method] = p
+	}
+
+	return policies
+}
+
+func targetUserID(req interface{}) string {
+	switch r := req.(type) {
+	case userIDRequest:
+		return r.GetUserId()
+	case userRequest:
+		return r.GetUser().GetUserId()
+	}
+	return ""
+}
+
+func samePolicy(a config.Policy, b config.Policy) bool {
+	if a.Owner != b.Owner || len(a.Roles) != len(b.Roles) {
+		return false
+	}
+	for _, role := range a.Roles {
+		if !hasRole(b.Roles, role) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package interceptors
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/JamesHsu333/go-grpc/config"
+	"github.com/JamesHsu333/go-grpc/internal/models"
+	"github.com/JamesHsu333/go-grpc/internal/user"
+	"github.com/JamesHsu333/go-grpc/pkg/grpc_errors"
+	"github.com/JamesHsu333/go-grpc/pkg/logger"
+	"github.com/JamesHsu333/go-grpc/pkg/utils"
+	userProto "github.com/JamesHsu333/go-grpc/proto/user"
+)
+
+// User usecase serving users by id, other methods are not used by authorization
+type usersByID struct {
+	user.UseCase
+	users map[uuid.UUID]*models.User
+}
+
+func (u *usersByID) GetByID(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	found, ok := u.users[userID]
+	if !ok {
+		return nil, grpc_errors.ErrNotFound
+	}
+	return found, nil
+}
+
+func newTestUser(role string) *models.User {
+	return &models.User{UserID: uuid.New(), Role: &role}
+}
+
+func newTestInterceptorManager(cfg *config.Config, users ...*models.User) *InterceptorManager {
+	cfg.RBAC.AdminRole = "admin"
+	cfg.Logger.Level = "fatal"
+	apiLogger := logger.NewApiLogger(cfg)
+	apiLogger.InitLogger()
+
+	userUC := &usersByID{users: make(map[uuid.UUID]*models.User, len(users))}
+	for _, u := range users {
+		userUC.users[u.UserID] = u
+	}
+	return NewInterceptorManager(apiLogger, cfg, nil, nil, userUC)
+}
+
+func TestAuthorize(t *testing.T) {
+	admin := newTestUser("admin")
+	support := newTestUser("support")
+	plain := newTestUser("user")
+	im := newTestInterceptorManager(&config.Config{}, admin, support, plain)
+
+	tests := []struct {
+		name   string
+		method string
+		caller *models.User
+		req    interface{}
+		want   codes.Code
+	}{
+		{name: "admin searches users", method: "/user.UserService/SearchUsers", caller: admin, want: codes.OK},
+		{name: "support searches users", method: "/user.UserService/SearchUsers", caller: support, want: codes.PermissionDenied},
+		{name: "user searches users", method: "/user.UserService/SearchUsers", caller: plain, want: codes.PermissionDenied},
+		{name: "user without policy", method: "/user.UserService/GetMe", caller: plain, want: codes.OK},
+		{
+			name:   "owner updates self",
+			method: "/user.UserService/Update",
+			caller: plain,
+			req:    &userProto.UpdateRequest{User: &userProto.User{UserId: plain.UserID.String()}},
+			want:   codes.OK,
+		},
+		{
+			name:   "support updates admin",
+			method: "/user.UserService/Update",
+			caller: support,
+			req:    &userProto.UpdateRequest{User: &userProto.User{UserId: admin.UserID.String()}},
+			want:   codes.PermissionDenied,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := utils.ContextWithSession(context.Background(), &models.Session{UserID: tt.caller.UserID})
+			err := im.authorize(ctx, tt.method, tt.req)
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("authorize() code = %v, want %v, err = %v", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestAuthorizeConfigPolicies(t *testing.T) {
+	admin := newTestUser("admin")
+	support := newTestUser("support")
+	plain := newTestUser("user")
+	cfg := &config.Config{RBAC: config.RBAC{Policies: []config.Policy{
+		{Method: "/user.UserService/ExportUsers", Roles: []string{"admin", "support"}},
+		{Method: "/user.UserService/Update", Roles: []string{"admin"}},
+		{Method: "/user.UserService/GetMe", Roles: []string{"admin"}},
+	}}}
+	im := newTestInterceptorManager(cfg, admin, support, plain)
+
+	tests := []struct {
+		name   string
+		method string
+		caller *models.User
+		req    interface{}
+		want   codes.Code
+	}{
+		{name: "config grants role", method: "/user.UserService/ExportUsers", caller: support, want: codes.OK},
+		{name: "role not granted", method: "/user.UserService/ExportUsers", caller: plain, want: codes.PermissionDenied},
+		{
+			name:   "config drops owner",
+			method: "/user.UserService/Update",
+			caller: plain,
+			req:    &userProto.UpdateRequest{User: &userProto.User{UserId: plain.UserID.String()}},
+			want:   codes.PermissionDenied,
+		},
+		{name: "config adds policy", method: "/user.UserService/GetMe", caller: plain, want: codes.PermissionDenied},
+		{name: "default kept", method: "/user.UserService/SearchUsers", caller: support, want: codes.PermissionDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := utils.ContextWithSession(context.Background(), &models.Session{UserID: tt.caller.UserID})
+			err := im.authorize(ctx, tt.method, tt.req)
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("authorize() code = %v, want %v, err = %v", got, tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Audited actions
+const (
+	AuditActionRegister    = "Register"
+	AuditActionImport      = "Import"
+	AuditActionUpdate      = "Update"
+	AuditActionUpdateRole  = "UpdateRole"
+	AuditActionDelete      = "Delete"
+	AuditActionRestore     = "Restore"
+	AuditActionLogin       = "Login"
+	AuditActionLoginFailed = "LoginFailed"
+	AuditActionLogout      = "Logout"
+)
+
+// Field value before and after change
+type FieldChange struct {
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// Changed fields keyed by field name, stored as jsonb
+type AuditChanges map[string]FieldChange
+
+// Value implements driver.Valuer
+func (c AuditChanges) Value() (driver.Value, error) {
+	if c == nil {
+		return "{}", nil
+	}
+	changesBytes, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(changesBytes), nil
+}
+
+// Scan implements sql.Scanner
+func (c *AuditChanges) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*c = AuditChanges{}
+		return nil
+	case []byte:
+		return json.Unmarshal(v, c)
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	}
+	return fmt.Errorf("AuditChanges.Scan: unsupported type %T", src)
+}
+
+// Audit event of user mutation, actor is the authenticated user or the target itself for anonymous calls
+type AuditEvent struct {
+	EventID   uuid.UUID    `json:"event_id" db:"event_id"`
+	ActorID   uuid.UUID    `json:"actor_id" db:"actor_id"`
+	TargetID  uuid.UUID    `json:"target_id" db:"target_id"`
+	Action    string       `json:"action" db:"action"`
+	Changes   AuditChanges `json:"changes" db:"changes"`
+	IPAddress string       `json:"ip_address" db:"ip_address"`
+	CreatedAt time.Time    `json:"created_at" db:"created_at"`
+}
+
+// Audit events filter, empty fields are ignored
+type AuditFilter struct {
+	ActorID   *uuid.UUID `json:"actor_id,omitempty"`
+	TargetID  *uuid.UUID `json:"target_id,omitempty"`
+	Actions   []string   `json:"actions,omitempty"`
+	CreatedAt TimeRange  `json:"created_at"`
+}
+
+// Audit events page, newest first
+type AuditEventsList struct {
+	Page    int           `json:"page"`
+	Size    int           `json:"size"`
+	HasMore bool          `json:"has_more"`
+	Events  []*AuditEvent `json:"events"`
+}
+
+// Diff profile fields of two user states, password is never included
+func DiffUsers(before *User, after *User) AuditChanges {
+	changes := AuditChanges{}
+	add := func(field string, b string, a string) {
+		if b != a {
+			changes[field] = FieldChange{Before: b, After: a}
+		}
+	}
+
+	add("first_name", before.FirstName, after.FirstName)
+	add("last_name", before.LastName, after.LastName)
+	add("email", before.Email, after.Email)
+	add("email_verified", strconv.FormatBool(before.EmailVerified), strconv.FormatBool(after.EmailVerified))
+	add("role", before.GetRole(), after.GetRole())
+	add("about", before.GetAbout(), after.GetAbout())
+	add("avatar", before.GetAvatar(), after.GetAvatar())
+	add("phone_number", before.GetPhoneNumber(), after.GetPhoneNumber())
+	add("address", before.GetAddress(), after.GetAddress())
+	add("city", before.GetCity(), after.GetCity())
+	add("country", before.GetCountry(), after.GetCountry())
+	add("gender", before.GetGender(), after.GetGender())
+	add("postcode", formatPostcode(before.Postcode), formatPostcode(after.Postcode))
+	add("birthday", formatBirthday(before.Birthday), formatBirthday(after.Birthday))
+
+	return changes
+}
+
+func formatPostcode(postcode *int) string {
+	if postcode == nil {
+		return ""
+	}
+	return strconv.Itoa(*postcode)
+}
+
+func formatBirthday(birthday *time.Time) string {
+	if birthday == nil {
+		return ""
+	}
+	return birthday.Format("2006-01-02")
+}
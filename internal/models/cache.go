@@ -0,0 +1,25 @@
+package models
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Cached user lookup, User is nil for ids known not to exist. Delta is how long loading the entry took
+type UserCacheEntry struct {
+	User      *User         `json:"user"`
+	Delta     time.Duration `json:"delta"`
+	ExpiresAt time.Time     `json:"expires_at"`
+}
+
+// Probabilistic early expiration, entries slow to load are refreshed earlier and refresh gets likelier
+// closer to expiry, so usually a single caller reloads entry before it expires. Higher beta refreshes earlier
+func (e *UserCacheEntry) ShouldRefresh(now time.Time, beta float64) bool {
+	if e.User == nil || e.Delta <= 0 || e.ExpiresAt.IsZero() {
+		return false
+	}
+
+	gap := time.Duration(float64(e.Delta) * beta * -math.Log(1-rand.Float64()))
+	return !now.Add(gap).Before(e.ExpiresAt)
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUserCacheEntryShouldRefresh(t *testing.T) {
+	now := time.Date(2021, time.January, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		entry *UserCacheEntry
+		beta  float64
+		want  bool
+	}{
+		{
+			name:  "missing user is never refreshed",
+			entry: &UserCacheEntry{Delta: time.Second, ExpiresAt: now.Add(-time.Minute)},
+			beta:  1,
+			want:  false,
+		},
+		{
+			name:  "unknown load time",
+			entry: &UserCacheEntry{User: &User{}, ExpiresAt: now.Add(-time.Minute)},
+			beta:  1,
+			want:  false,
+		},
+		{
+			name:  "unknown expiry",
+			entry: &UserCacheEntry{User: &User{}, Delta: time.Second},
+			beta:  1,
+			want:  false,
+		},
+		{
+			name:  "expired",
+			entry: &UserCacheEntry{User: &User{}, Delta: time.Millisecond, ExpiresAt: now.Add(-time.Second)},
+			beta:  1,
+			want:  true,
+		},
+		{
+			name:  "expires now",
+			entry: &UserCacheEntry{User: &User{}, Delta: time.Millisecond, ExpiresAt: now},
+			beta:  1,
+			want:  true,
+		},
+		{
+			name:  "far from expiry",
+			entry: &UserCacheEntry{User: &User{}, Delta: time.Millisecond, ExpiresAt: now.Add(time.Hour)},
+			beta:  1,
+			want:  false,
+		},
+		{
+			name:  "zero beta refreshes only once expired",
+			entry: &UserCacheEntry{User: &User{}, Delta: time.Hour, ExpiresAt: now.Add(time.Second)},
+			beta:  0,
+			want:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Gap is random, every case is decided for any sample of it
+			for i := 0; i < 100; i++ {
+				if got := tt.entry.ShouldRefresh(now, tt.beta); got != tt.want {
+					t.Fatalf("ShouldRefresh() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestUserCacheEntryShouldRefreshSlowLoadNearExpiry(t *testing.T) {
+	now := time.Date(2021, time.January, 2, 3, 4, 5, 0, time.UTC)
+	entry := &UserCacheEntry{User: &User{}, Delta: time.Minute, ExpiresAt: now.Add(time.Second)}
+
+	// Gap is below a second with probability 1-e^(-1/60), under 2%, so nearly every call refreshes
+	refreshed := 0
+	for i := 0; i < 1000; i++ {
+		if entry.ShouldRefresh(now, 1) {
+			refreshed++
+		}
+	}
+	if refreshed < 900 {
+		t.Errorf("ShouldRefresh() refreshed %d of 1000 times, want at least 900", refreshed)
+	}
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Types of user changes
+const (
+	UserChangeCreated = "created"
+	UserChangeUpdated = "updated"
+	UserChangeDeleted = "deleted"
+)
+
+// Change of user recorded by users table trigger, User is current state of not deleted user
+type UserChange struct {
+	ChangeID    int64     `json:"change_id" db:"change_id"`
+	TxID        int64     `json:"txid" db:"txid"`
+	UserID      uuid.UUID `json:"user_id" db:"user_id"`
+	Type        string    `json:"change_type" db:"change_type"`
+	ChangedAt   time.Time `json:"changed_at" db:"changed_at"`
+	ResumeToken string    `json:"resume_token" db:"-"`
+	User        *User     `json:"user,omitempty" db:"-"`
+}
+
+// Check user change type
+func IsUserChangeType(changeType string) bool {
+	switch changeType {
+	case UserChangeCreated, UserChangeUpdated, UserChangeDeleted:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// User domain event types
+const (
+	EventUserRegistered  = "user.registered"
+	EventUserUpdated     = "user.updated"
+	EventUserRoleChanged = "user.role_changed"
+	EventUserDeleted     = "user.deleted"
+	EventUserRestored    = "user.restored"
+)
+
+// Domain event, stored in outbox in the same transaction as the change and published by relay
+type OutboxEvent struct {
+	EventID       uuid.UUID       `json:"event_id" db:"event_id"`
+	AggregateID   uuid.UUID       `json:"aggregate_id" db:"aggregate_id"`
+	Type          string          `json:"type" db:"event_type"`
+	Payload       json.RawMessage `json:"payload" db:"payload"`
+	CreatedAt     time.Time       `json:"created_at" db:"created_at"`
+	PublishedAt   *time.Time      `json:"published_at,omitempty" db:"published_at"`
+	FailedAt      *time.Time      `json:"failed_at,omitempty" db:"failed_at"`
+	Attempts      int             `json:"attempts" db:"attempts"`
+	LastError     string          `json:"last_error,omitempty" db:"last_error"`
+	NextAttemptAt time.Time       `json:"next_attempt_at" db:"next_attempt_at"`
+}
+
+// Payload of user domain events, Changes are set for updates and never contain password
+type UserEventPayload struct {
+	UserID  uuid.UUID    `json:"user_id"`
+	Email   string       `json:"email,omitempty"`
+	Role    string       `json:"role,omitempty"`
+	Changes AuditChanges `json:"changes,omitempty"`
+}
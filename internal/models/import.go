@@ -0,0 +1,17 @@
+package models
+
+import "github.com/google/uuid"
+
+// User to import, Line is the row number in source file
+type ImportRow struct {
+	Line int64
+	User *User
+}
+
+// Outcome of importing row, Err is nil when user was imported or, in dry run, would be
+type ImportResult struct {
+	Line   int64
+	Email  string
+	UserID uuid.UUID
+	Err    error
+}
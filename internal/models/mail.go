@@ -0,0 +1,16 @@
+package models
+
+import "github.com/google/uuid"
+
+// Mail message
+type Mail struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Email verification token owner, token is valid only while user still has the email it was sent to
+type EmailToken struct {
+	UserID uuid.UUID `json:"user_id"`
+	Email  string    `json:"email"`
+}
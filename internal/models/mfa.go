@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// User TOTP second factor, secret is never returned to clients after enrollment
+type TOTP struct {
+	UserID      uuid.UUID  `json:"user_id" db:"user_id"`
+	Secret      string     `json:"-" db:"secret"`
+	Enabled     bool       `json:"enabled" db:"enabled"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty" db:"confirmed_at"`
+}
+
+// TOTP enrollment returned once to the user
+type TOTPEnrollment struct {
+	Secret        string
+	URL           string
+	RecoveryCodes []string
+}
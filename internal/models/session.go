@@ -1,9 +1,26 @@
 package models
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 // Session model
 type Session struct {
+	SessionID   string    `json:"session_id" redis:"session_id"`
+	UserID      uuid.UUID `json:"user_id" redis:"user_id"`
+	IPAddress   string    `json:"ip_address,omitempty" redis:"ip_address"`
+	UserAgent   string    `json:"user_agent,omitempty" redis:"user_agent"`
+	CreatedAt   time.Time `json:"created_at" redis:"created_at"`
+	RefreshedAt time.Time `json:"refreshed_at" redis:"refreshed_at"`
+	ExpiresAt   time.Time `json:"expires_at" redis:"expires_at"`
+}
+
+// Refresh token family, only the latest rotated token of family is valid
+type RefreshToken struct {
+	FamilyID  string    `json:"family_id" redis:"family_id"`
 	SessionID string    `json:"session_id" redis:"session_id"`
 	UserID    uuid.UUID `json:"user_id" redis:"user_id"`
+	TokenHash string    `json:"token_hash" redis:"token_hash"`
 }
@@ -10,24 +10,42 @@ import (
 
 // User full model
 type User struct {
-	UserID      uuid.UUID  `json:"user_id" db:"user_id" redis:"user_id" validate:"omitempty"`
-	FirstName   string     `json:"first_name" db:"first_name" redis:"first_name" validate:"required,lte=30"`
-	LastName    string     `json:"last_name" db:"last_name" redis:"last_name" validate:"required,lte=30"`
-	Email       string     `json:"email,omitempty" db:"email" redis:"email" validate:"omitempty,lte=60,email"`
-	Password    string     `json:"password,omitempty" db:"password" redis:"password" validate:"omitempty,required,gte=6"`
-	Role        *string    `json:"role,omitempty" db:"role" redis:"role" validate:"omitempty,lte=10"`
-	About       *string    `json:"about,omitempty" db:"about" redis:"about" validate:"omitempty,lte=1024"`
-	Avatar      *string    `json:"avatar,omitempty" db:"avatar" redis:"avatar" validate:"omitempty,lte=512,url"`
-	PhoneNumber *string    `json:"phone_number,omitempty" db:"phone_number" redis:"phone_number" validate:"omitempty,lte=20"`
-	Address     *string    `json:"address,omitempty" db:"address" redis:"address" validate:"omitempty,lte=250"`
-	City        *string    `json:"city,omitempty" db:"city" redis:"city" validate:"omitempty,lte=24"`
-	Country     *string    `json:"country,omitempty" db:"country" redis:"country" validate:"omitempty,lte=24"`
-	Gender      *string    `json:"gender,omitempty" db:"gender" redis:"gender" validate:"omitempty,lte=10"`
-	Postcode    *int       `json:"postcode,omitempty" db:"postcode" redis:"postcode" validate:"omitempty"`
-	Birthday    *time.Time `json:"birthday,omitempty" db:"birthday" redis:"birthday" validate:"omitempty,lte=10"`
-	CreatedAt   time.Time  `json:"created_at,omitempty" db:"created_at" redis:"created_at"`
-	UpdatedAt   time.Time  `json:"updated_at,omitempty" db:"updated_at" redis:"updated_at"`
-	LoginDate   time.Time  `json:"login_date" db:"login_date" redis:"login_date"`
+	UserID        uuid.UUID  `json:"user_id" db:"user_id" redis:"user_id" validate:"omitempty"`
+	FirstName     string     `json:"first_name" db:"first_name" redis:"first_name" validate:"required,lte=30"`
+	LastName      string     `json:"last_name" db:"last_name" redis:"last_name" validate:"required,lte=30"`
+	Email         string     `json:"email,omitempty" db:"email" redis:"email" validate:"required,lte=60,email"`
+	EmailVerified bool       `json:"email_verified" db:"email_verified" redis:"email_verified"`
+	Password      string     `json:"password,omitempty" db:"password" redis:"password" validate:"omitempty,required,gte=6"`
+	Role          *string    `json:"role,omitempty" db:"role" redis:"role" validate:"omitempty,lte=10"`
+	About         *string    `json:"about,omitempty" db:"about" redis:"about" validate:"omitempty,lte=1024"`
+	Avatar        *string    `json:"avatar,omitempty" db:"avatar" redis:"avatar" validate:"omitempty,lte=512"`
+	PhoneNumber   *string    `json:"phone_number,omitempty" db:"phone_number" redis:"phone_number" validate:"omitempty,lte=20"`
+	Address       *string    `json:"address,omitempty" db:"address" redis:"address" validate:"omitempty,lte=250"`
+	City          *string    `json:"city,omitempty" db:"city" redis:"city" validate:"omitempty,lte=24"`
+	Country       *string    `json:"country,omitempty" db:"country" redis:"country" validate:"omitempty,lte=24"`
+	Gender        *string    `json:"gender,omitempty" db:"gender" redis:"gender" validate:"omitempty,lte=10"`
+	Postcode      *int       `json:"postcode,omitempty" db:"postcode" redis:"postcode" validate:"omitempty"`
+	Birthday      *time.Time `json:"birthday,omitempty" db:"birthday" redis:"birthday" validate:"omitempty,lte=10"`
+	CreatedAt     time.Time  `json:"created_at,omitempty" db:"created_at" redis:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at,omitempty" db:"updated_at" redis:"updated_at"`
+	LoginDate     time.Time  `json:"login_date" db:"login_date" redis:"login_date"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty" db:"deleted_at" redis:"deleted_at"`
+	Version       int64      `json:"version" db:"version" redis:"version"`
+}
+
+// User fields that can be set by update mask, api field name to struct field name
+var UserUpdateMaskFields = map[string]string{
+	"first_name":   "FirstName",
+	"last_name":    "LastName",
+	"email":        "Email",
+	"about":        "About",
+	"phone_number": "PhoneNumber",
+	"address":      "Address",
+	"city":         "City",
+	"country":      "Country",
+	"gender":       "Gender",
+	"postcode":     "Postcode",
+	"birthday":     "Birthday",
 }
 
 // Hash user password with bcrypt
@@ -150,10 +168,46 @@ func (u *User) GetGender() string {
 
 // All Users response
 type UsersList struct {
-	TotalCount int     `json:"total_count"`
-	TotalPages int     `json:"total_pages"`
-	Page       int     `json:"page"`
-	Size       int     `json:"size"`
-	HasMore    bool    `json:"has_more"`
-	Users      []*User `json:"users"`
+	TotalCount    int     `json:"total_count"`
+	TotalPages    int     `json:"total_pages"`
+	Page          int     `json:"page"`
+	Size          int     `json:"size"`
+	HasMore       bool    `json:"has_more"`
+	NextPageToken string  `json:"next_page_token,omitempty"`
+	PrevPageToken string  `json:"prev_page_token,omitempty"`
+	Users         []*User `json:"users"`
+}
+
+// User search hit with relevance rank and highlighted matches
+type UserSearchResult struct {
+	User
+	Rank      float64 `json:"rank" db:"rank"`
+	Highlight string  `json:"highlight" db:"highlight"`
+}
+
+// Search results ordered by relevance
+type UserSearchList struct {
+	Page    int                 `json:"page"`
+	Size    int                 `json:"size"`
+	HasMore bool                `json:"has_more"`
+	Results []*UserSearchResult `json:"results"`
+}
+
+// Time range filter, From is inclusive, To is exclusive and nil bound is open
+type TimeRange struct {
+	From *time.Time `json:"from,omitempty"`
+	To   *time.Time `json:"to,omitempty"`
+}
+
+// Users listing filter, in-list fields match any of the values and empty fields are ignored
+type UserFilter struct {
+	Roles          []string  `json:"roles,omitempty"`
+	Genders        []string  `json:"genders,omitempty"`
+	Cities         []string  `json:"cities,omitempty"`
+	Countries      []string  `json:"countries,omitempty"`
+	CreatedAt      TimeRange `json:"created_at"`
+	UpdatedAt      TimeRange `json:"updated_at"`
+	LoginDate      TimeRange `json:"login_date"`
+	Birthday       TimeRange `json:"birthday"`
+	IncludeDeleted bool      `json:"include_deleted,omitempty"`
 }
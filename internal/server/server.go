@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"os"
@@ -13,6 +14,7 @@ import (
 	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/keepalive"
@@ -22,9 +24,11 @@ import (
 	"github.com/JamesHsu333/go-grpc/internal/interceptors"
 	sessRepository "github.com/JamesHsu333/go-grpc/internal/session/repository"
 	sessUseCase "github.com/JamesHsu333/go-grpc/internal/session/usecase"
+	"github.com/JamesHsu333/go-grpc/internal/user"
 	userServerGRPC "github.com/JamesHsu333/go-grpc/internal/user/delivery/grpc"
 	userRepository "github.com/JamesHsu333/go-grpc/internal/user/repository"
 	userUseCase "github.com/JamesHsu333/go-grpc/internal/user/usecase"
+	userWorker "github.com/JamesHsu333/go-grpc/internal/user/worker"
 	"github.com/JamesHsu333/go-grpc/pkg/logger"
 	"github.com/JamesHsu333/go-grpc/pkg/metric"
 	userProto "github.com/JamesHsu333/go-grpc/proto/user"
@@ -54,12 +58,44 @@ func (s *Server) Run() error {
 		s.cfg.Metrics.ServiceName,
 	)
 
-	im := interceptors.NewInterceptorManager(s.logger, s.cfg, metrics)
 	userRepo := userRepository.NewUserRepository(s.db)
+	auditRepo := userRepository.NewAuditRepository(s.db)
+	outboxRepo := userRepository.NewOutboxRepository(s.db)
+	changesRepo := userRepository.NewUserChangesRepository(s.db, s.cfg, s.logger)
 	sessRepo := sessRepository.NewSessionRepository(s.redisClient, s.cfg)
 	userRedisRepo := userRepository.NewUserRedisRepo(s.redisClient, s.logger)
-	userUC := userUseCase.NewUserUC(userRepo, userRedisRepo, s.logger)
+	userFileRepo := userRepository.NewuserFileRepository(s.cfg)
+	userMailSender := userRepository.NewUserMailFileSender(s.cfg)
+	if s.cfg.Mail.Driver == "smtp" {
+		userMailSender = userRepository.NewUserMailSMTPSender(s.cfg)
+	}
 	sessUC := sessUseCase.NewSessionUseCase(sessRepo, s.cfg)
+	userUC := userUseCase.NewUserUC(userRepo, auditRepo, outboxRepo, changesRepo, userRedisRepo, userFileRepo, userMailSender, sessUC, s.cfg, metrics, s.logger)
+	im := interceptors.NewInterceptorManager(s.logger, s.cfg, metrics, sessUC, userUC)
+
+	var eventPublisher user.EventPublisher
+	switch s.cfg.Outbox.Publisher {
+	case "memory":
+		eventPublisher = userRepository.NewUserEventMemoryPublisher(s.logger)
+	case "redis":
+		eventPublisher = userRepository.NewUserEventRedisPublisher(s.redisClient, s.cfg)
+	default:
+		return errors.Errorf("unknown outbox publisher %q", s.cfg.Outbox.Publisher)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	purgeWorker := userWorker.NewPurgeWorker(userUC, s.cfg, s.logger)
+	go purgeWorker.Run(ctx)
+
+	changesCleanupWorker := userWorker.NewChangesCleanupWorker(userUC, s.cfg, s.logger)
+	go changesCleanupWorker.Run(ctx)
+
+	outboxRelay := userWorker.NewOutboxRelay(outboxRepo, eventPublisher, s.cfg, metrics, s.logger)
+	go outboxRelay.Run(ctx)
+
+	go changesRepo.Listen(ctx)
 
 	l, err := net.Listen("tcp", s.cfg.Server.Port)
 	if err != nil {
@@ -78,6 +114,15 @@ func (s *Server) Run() error {
 			grpc_ctxtags.UnaryServerInterceptor(),
 			grpc_prometheus.UnaryServerInterceptor,
 			grpcrecovery.UnaryServerInterceptor(),
+			im.Auth,
+			im.Authorize,
+		),
+		grpc.ChainStreamInterceptor(
+			grpc_ctxtags.StreamServerInterceptor(),
+			grpc_prometheus.StreamServerInterceptor,
+			grpcrecovery.StreamServerInterceptor(),
+			im.StreamAuth,
+			im.StreamAuthorize,
 		),
 	)
 
@@ -5,11 +5,19 @@ import (
 	"context"
 
 	"github.com/JamesHsu333/go-grpc/internal/models"
+	"github.com/google/uuid"
 )
 
 // Session repository
 type SessRepository interface {
 	CreateSession(ctx context.Context, session *models.Session, expire int) (string, error)
 	GetSessionByID(ctx context.Context, sessionID string) (*models.Session, error)
+	Touch(ctx context.Context, sessionID string) (*models.Session, error)
+	RefreshSession(ctx context.Context, sessionID string) (*models.Session, error)
+	GetSessionsByUserID(ctx context.Context, userID uuid.UUID) ([]*models.Session, error)
 	DeleteByID(ctx context.Context, sessionID string) error
+	DeleteByUserID(ctx context.Context, userID uuid.UUID) error
+	CreateRefreshToken(ctx context.Context, session *models.Session, expire int) (string, error)
+	RotateRefreshToken(ctx context.Context, token string, expire int) (*models.RefreshToken, string, error)
+	DeleteRefreshToken(ctx context.Context, familyID string) error
 }
@@ -4,11 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/JamesHsu333/go-grpc/config"
 	"github.com/JamesHsu333/go-grpc/internal/models"
 	"github.com/JamesHsu333/go-grpc/internal/session"
+	"github.com/JamesHsu333/go-grpc/pkg/grpc_errors"
+	"github.com/JamesHsu333/go-grpc/pkg/utils"
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	"github.com/opentracing/opentracing-go"
@@ -16,7 +19,9 @@ import (
 )
 
 const (
-	basePrefix = "api-session:"
+	basePrefix     = "api-session:"
+	refreshPrefix  = "api-refresh:"
+	userSessPrefix = "api-user-sessions:"
 )
 
 // Session repository
@@ -31,22 +36,33 @@ func NewSessionRepository(redisClient *redis.Client, cfg *config.Config) session
 	return &SessionRepo{redisClient: redisClient, basePrefix: basePrefix, cfg: cfg}
 }
 
-// Create session in redis
+// Create session in redis, expire is idle timeout in seconds
 func (s *SessionRepo) CreateSession(ctx context.Context, sess *models.Session, expire int) (string, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "SessionRepo.CreateSession")
 	defer span.Finish()
 
+	now := time.Now().UTC()
 	sess.SessionID = uuid.New().String()
+	sess.CreatedAt = now
+	sess.RefreshedAt = now
+	sess.ExpiresAt = s.getExpiresAt(sess, now, expire)
 	sessionKey := s.createKey(sess.SessionID)
 
 	sessBytes, err := json.Marshal(&sess)
 	if err != nil {
 		return "", errors.WithMessage(err, "SessionRepo.CreateSession.json.Marshal")
 	}
-	if err = s.redisClient.Set(ctx, sessionKey, sessBytes, time.Second*time.Duration(expire)).Err(); err != nil {
-		return "", errors.Wrap(err, "SessionRepo.CreateSession.redisClient.Set")
+
+	userSessKey := s.createUserSessKey(sess.UserID.String())
+	if _, err = s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, sessionKey, sessBytes, sess.ExpiresAt.Sub(now))
+		pipe.SAdd(ctx, userSessKey, sess.SessionID)
+		pipe.Expire(ctx, userSessKey, s.getUserSessTTL(expire))
+		return nil
+	}); err != nil {
+		return "", errors.Wrap(err, "SessionRepo.CreateSession.redisClient.TxPipelined")
 	}
-	return sessionKey, nil
+	return sess.SessionID, nil
 }
 
 // Get session by id
@@ -54,7 +70,7 @@ func (s *SessionRepo) GetSessionByID(ctx context.Context, sessionID string) (*mo
 	span, ctx := opentracing.StartSpanFromContext(ctx, "SessionRepo.GetSessionByID")
 	defer span.Finish()
 
-	sessBytes, err := s.redisClient.Get(ctx, sessionID).Bytes()
+	sessBytes, err := s.redisClient.Get(ctx, s.createKey(sessionID)).Bytes()
 	if err != nil {
 		return nil, errors.Wrap(err, "SessionRep.GetSessionByID.redisClient.Get")
 	}
@@ -66,17 +82,308 @@ func (s *SessionRepo) GetSessionByID(ctx context.Context, sessionID string) (*mo
 	return sess, nil
 }
 
+// Get session by id on its use, session is extended by idle timeout at most once per refresh interval
+func (s *SessionRepo) Touch(ctx context.Context, sessionID string) (*models.Session, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "SessionRepo.Touch")
+	defer span.Finish()
+
+	sess, err := s.GetSessionByID(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Since(sess.RefreshedAt) < time.Duration(s.cfg.Session.RefreshInterval)*time.Second {
+		return sess, nil
+	}
+
+	if err = s.touchSession(ctx, sess); err != nil {
+		return nil, errors.Wrap(err, "SessionRepo.Touch.touchSession")
+	}
+	return sess, nil
+}
+
+// Extend session by idle timeout regardless of refresh interval
+func (s *SessionRepo) RefreshSession(ctx context.Context, sessionID string) (*models.Session, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "SessionRepo.RefreshSession")
+	defer span.Finish()
+
+	sessBytes, err := s.redisClient.Get(ctx, s.createKey(sessionID)).Bytes()
+	if err != nil {
+		return nil, errors.Wrap(err, "SessionRepo.RefreshSession.redisClient.Get")
+	}
+
+	sess := &models.Session{}
+	if err = json.Unmarshal(sessBytes, &sess); err != nil {
+		return nil, errors.Wrap(err, "SessionRepo.RefreshSession.json.Unmarshal")
+	}
+
+	if err = s.touchSession(ctx, sess); err != nil {
+		return nil, errors.Wrap(err, "SessionRepo.RefreshSession.touchSession")
+	}
+	return sess, nil
+}
+
+// Rewrite session with new ttl, deleted sessions are not recreated. Max lifetime of sessions created
+// before creation time was recorded starts with their first refresh, they join user index on it
+func (s *SessionRepo) touchSession(ctx context.Context, sess *models.Session) error {
+	now := time.Now().UTC()
+	if sess.CreatedAt.IsZero() {
+		sess.CreatedAt = now
+	}
+	expiresAt := s.getExpiresAt(sess, now, s.cfg.Session.Expire)
+	if !expiresAt.After(now) {
+		return redis.Nil
+	}
+
+	sess.RefreshedAt = now
+	sess.ExpiresAt = expiresAt
+
+	sessBytes, err := json.Marshal(&sess)
+	if err != nil {
+		return errors.WithMessage(err, "json.Marshal")
+	}
+
+	userSessKey := s.createUserSessKey(sess.UserID.String())
+	var setCmd *redis.BoolCmd
+	if _, err = s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		setCmd = pipe.SetXX(ctx, s.createKey(sess.SessionID), sessBytes, expiresAt.Sub(now))
+		pipe.SAdd(ctx, userSessKey, sess.SessionID)
+		pipe.Expire(ctx, userSessKey, s.getUserSessTTL(s.cfg.Session.Expire))
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "redisClient.TxPipelined")
+	}
+	if !setCmd.Val() {
+		return redis.Nil
+	}
+	return nil
+}
+
+// Session expires after idle timeout but not later than max lifetime since creation
+func (s *SessionRepo) getExpiresAt(sess *models.Session, now time.Time, expire int) time.Time {
+	expiresAt := now.Add(time.Duration(expire) * time.Second)
+	if s.cfg.Session.MaxLifetime > 0 && !sess.CreatedAt.IsZero() {
+		deadline := sess.CreatedAt.Add(time.Duration(s.cfg.Session.MaxLifetime) * time.Second)
+		if expiresAt.After(deadline) {
+			return deadline
+		}
+	}
+	return expiresAt
+}
+
+// User sessions index lives as long as longest possible session
+func (s *SessionRepo) getUserSessTTL(expire int) time.Duration {
+	if s.cfg.Session.MaxLifetime > expire {
+		return time.Duration(s.cfg.Session.MaxLifetime) * time.Second
+	}
+	return time.Duration(expire) * time.Second
+}
+
+// Get all active sessions of user, expired sessions are removed from user index
+func (s *SessionRepo) GetSessionsByUserID(ctx context.Context, userID uuid.UUID) ([]*models.Session, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "SessionRepo.GetSessionsByUserID")
+	defer span.Finish()
+
+	userSessKey := s.createUserSessKey(userID.String())
+	sessionIDs, err := s.redisClient.SMembers(ctx, userSessKey).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "SessionRepo.GetSessionsByUserID.SMembers")
+	}
+
+	sessions := make([]*models.Session, 0, len(sessionIDs))
+	if len(sessionIDs) == 0 {
+		return sessions, nil
+	}
+
+	keys := make([]string, 0, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		keys = append(keys, s.createKey(sessionID))
+	}
+
+	values, err := s.redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "SessionRepo.GetSessionsByUserID.MGet")
+	}
+
+	expired := make([]interface{}, 0)
+	for i, value := range values {
+		sessString, ok := value.(string)
+		if !ok {
+			expired = append(expired, sessionIDs[i])
+			continue
+		}
+
+		sess := &models.Session{}
+		if err = json.Unmarshal([]byte(sessString), sess); err != nil {
+			return nil, errors.Wrap(err, "SessionRepo.GetSessionsByUserID.json.Unmarshal")
+		}
+		sessions = append(sessions, sess)
+	}
+
+	if len(expired) > 0 {
+		if err = s.redisClient.SRem(ctx, userSessKey, expired...).Err(); err != nil {
+			return nil, errors.Wrap(err, "SessionRepo.GetSessionsByUserID.SRem")
+		}
+	}
+
+	return sessions, nil
+}
+
 // Delete session by id
 func (s *SessionRepo) DeleteByID(ctx context.Context, sessionID string) error {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "sessionRepo.DeleteByID")
 	defer span.Finish()
 
-	if err := s.redisClient.Del(ctx, sessionID).Err(); err != nil {
+	sess, err := s.GetSessionByID(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		return errors.Wrap(err, "sessionRepo.DeleteByID.GetSessionByID")
+	}
+
+	if _, err = s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, s.createKey(sessionID))
+		pipe.SRem(ctx, s.createUserSessKey(sess.UserID.String()), sessionID)
+		return nil
+	}); err != nil {
 		return errors.Wrap(err, "sessionRepo.DeleteByID")
 	}
 	return nil
 }
 
+// Delete all sessions of user
+func (s *SessionRepo) DeleteByUserID(ctx context.Context, userID uuid.UUID) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "sessionRepo.DeleteByUserID")
+	defer span.Finish()
+
+	userSessKey := s.createUserSessKey(userID.String())
+	sessionIDs, err := s.redisClient.SMembers(ctx, userSessKey).Result()
+	if err != nil {
+		return errors.Wrap(err, "sessionRepo.DeleteByUserID.SMembers")
+	}
+
+	keys := make([]string, 0, len(sessionIDs)+1)
+	for _, sessionID := range sessionIDs {
+		keys = append(keys, s.createKey(sessionID))
+	}
+	keys = append(keys, userSessKey)
+
+	if err = s.redisClient.Del(ctx, keys...).Err(); err != nil {
+		return errors.Wrap(err, "sessionRepo.DeleteByUserID.Del")
+	}
+	return nil
+}
+
+// Create refresh token family for session, returns refresh token
+func (s *SessionRepo) CreateRefreshToken(ctx context.Context, sess *models.Session, expire int) (string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "SessionRepo.CreateRefreshToken")
+	defer span.Finish()
+
+	secret, err := utils.GenerateRandomToken()
+	if err != nil {
+		return "", errors.Wrap(err, "SessionRepo.CreateRefreshToken.GenerateRandomToken")
+	}
+
+	rt := &models.RefreshToken{
+		FamilyID:  uuid.New().String(),
+		SessionID: sess.SessionID,
+		UserID:    sess.UserID,
+		TokenHash: utils.HashToken(secret),
+	}
+
+	rtBytes, err := json.Marshal(rt)
+	if err != nil {
+		return "", errors.WithMessage(err, "SessionRepo.CreateRefreshToken.json.Marshal")
+	}
+	if err = s.redisClient.Set(ctx, s.createRefreshKey(rt.FamilyID), rtBytes, time.Second*time.Duration(expire)).Err(); err != nil {
+		return "", errors.Wrap(err, "SessionRepo.CreateRefreshToken.redisClient.Set")
+	}
+
+	return rt.FamilyID + "." + secret, nil
+}
+
+// Rotate refresh token, presenting an already rotated token revokes the whole family and its session
+func (s *SessionRepo) RotateRefreshToken(ctx context.Context, token string, expire int) (*models.RefreshToken, string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "SessionRepo.RotateRefreshToken")
+	defer span.Finish()
+
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return nil, "", grpc_errors.ErrInvalidToken
+	}
+	familyKey := s.createRefreshKey(parts[0])
+
+	rt := &models.RefreshToken{}
+	var newToken string
+	err := s.redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		rtBytes, err := tx.Get(ctx, familyKey).Bytes()
+		if err != nil {
+			if err == redis.Nil {
+				return grpc_errors.ErrInvalidToken
+			}
+			return errors.Wrap(err, "SessionRepo.RotateRefreshToken.redisClient.Get")
+		}
+		if err = json.Unmarshal(rtBytes, rt); err != nil {
+			return errors.Wrap(err, "SessionRepo.RotateRefreshToken.json.Unmarshal")
+		}
+
+		if rt.TokenHash != utils.HashToken(parts[1]) {
+			if _, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Del(ctx, familyKey, s.createKey(rt.SessionID))
+				return nil
+			}); err != nil {
+				return errors.Wrap(err, "SessionRepo.RotateRefreshToken.TxPipelined.Del")
+			}
+			return grpc_errors.ErrTokenReused
+		}
+
+		secret, err := utils.GenerateRandomToken()
+		if err != nil {
+			return errors.Wrap(err, "SessionRepo.RotateRefreshToken.GenerateRandomToken")
+		}
+		rt.TokenHash = utils.HashToken(secret)
+
+		rtBytes, err = json.Marshal(rt)
+		if err != nil {
+			return errors.WithMessage(err, "SessionRepo.RotateRefreshToken.json.Marshal")
+		}
+		if _, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, familyKey, rtBytes, time.Second*time.Duration(expire))
+			return nil
+		}); err != nil {
+			return errors.Wrap(err, "SessionRepo.RotateRefreshToken.TxPipelined.Set")
+		}
+
+		newToken = rt.FamilyID + "." + secret
+		return nil
+	}, familyKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return rt, newToken, nil
+}
+
+// Delete refresh token family
+func (s *SessionRepo) DeleteRefreshToken(ctx context.Context, familyID string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "SessionRepo.DeleteRefreshToken")
+	defer span.Finish()
+
+	if err := s.redisClient.Del(ctx, s.createRefreshKey(familyID)).Err(); err != nil {
+		return errors.Wrap(err, "SessionRepo.DeleteRefreshToken")
+	}
+	return nil
+}
+
+func (s *SessionRepo) createRefreshKey(familyID string) string {
+	return fmt.Sprintf("%s: %s", refreshPrefix, familyID)
+}
+
+func (s *SessionRepo) createUserSessKey(userID string) string {
+	return fmt.Sprintf("%s: %s", userSessPrefix, userID)
+}
+
 func (s *SessionRepo) createKey(sessionID string) string {
 	return fmt.Sprintf("%s: %s", s.basePrefix, sessionID)
 }
@@ -6,7 +6,10 @@ import (
 	"github.com/JamesHsu333/go-grpc/config"
 	"github.com/JamesHsu333/go-grpc/internal/models"
 	"github.com/JamesHsu333/go-grpc/internal/session"
+	"github.com/JamesHsu333/go-grpc/pkg/grpc_errors"
+	"github.com/google/uuid"
 	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
 )
 
 // Session use case
@@ -36,6 +39,30 @@ func (u *SessionUC) DeleteByID(ctx context.Context, sessionID string) error {
 	return u.sessionRepo.DeleteByID(ctx, sessionID)
 }
 
+// Delete session by id only if it belongs to user
+func (u *SessionUC) DeleteUserSession(ctx context.Context, userID uuid.UUID, sessionID string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "sessionUC.DeleteUserSession")
+	defer span.Finish()
+
+	sess, err := u.sessionRepo.GetSessionByID(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if sess.UserID != userID {
+		return grpc_errors.ErrNotFound
+	}
+
+	return u.sessionRepo.DeleteByID(ctx, sessionID)
+}
+
+// Delete all sessions of user
+func (u *SessionUC) DeleteByUserID(ctx context.Context, userID uuid.UUID) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "sessionUC.DeleteByUserID")
+	defer span.Finish()
+
+	return u.sessionRepo.DeleteByUserID(ctx, userID)
+}
+
 // get session by id
 func (u *SessionUC) GetSessionByID(ctx context.Context, sessionID string) (*models.Session, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "sessionUC.GetSessionByID")
@@ -43,3 +70,56 @@ func (u *SessionUC) GetSessionByID(ctx context.Context, sessionID string) (*mode
 
 	return u.sessionRepo.GetSessionByID(ctx, sessionID)
 }
+
+// Get session by id on its use, extends session by idle timeout
+func (u *SessionUC) Touch(ctx context.Context, sessionID string) (*models.Session, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "sessionUC.Touch")
+	defer span.Finish()
+
+	return u.sessionRepo.Touch(ctx, sessionID)
+}
+
+// Create refresh token for session
+func (u *SessionUC) CreateRefreshToken(ctx context.Context, session *models.Session, expire int) (string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "sessionUC.CreateRefreshToken")
+	defer span.Finish()
+
+	return u.sessionRepo.CreateRefreshToken(ctx, session, expire)
+}
+
+// Rotate refresh token, returns session it belongs to and new refresh token
+func (u *SessionUC) RefreshToken(ctx context.Context, token string, expire int) (*models.Session, string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "sessionUC.RefreshToken")
+	defer span.Finish()
+
+	rt, newToken, err := u.sessionRepo.RotateRefreshToken(ctx, token, expire)
+	if err != nil {
+		return nil, "", err
+	}
+
+	session, err := u.sessionRepo.GetSessionByID(ctx, rt.SessionID)
+	if err != nil {
+		if err := u.sessionRepo.DeleteRefreshToken(ctx, rt.FamilyID); err != nil {
+			return nil, "", err
+		}
+		return nil, "", errors.Wrap(grpc_errors.ErrInvalidToken, err.Error())
+	}
+
+	return session, newToken, nil
+}
+
+// Extend session by idle timeout
+func (u *SessionUC) RefreshSession(ctx context.Context, sessionID string) (*models.Session, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "sessionUC.RefreshSession")
+	defer span.Finish()
+
+	return u.sessionRepo.RefreshSession(ctx, sessionID)
+}
+
+// Get all active sessions of user
+func (u *SessionUC) GetSessionsByUserID(ctx context.Context, userID uuid.UUID) ([]*models.Session, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "sessionUC.GetSessionsByUserID")
+	defer span.Finish()
+
+	return u.sessionRepo.GetSessionsByUserID(ctx, userID)
+}
@@ -0,0 +1,14 @@
+package user
+
+import (
+	"context"
+
+	"github.com/JamesHsu333/go-grpc/internal/models"
+	"github.com/JamesHsu333/go-grpc/pkg/utils"
+)
+
+// Audit repository interface
+type AuditRepository interface {
+	Create(ctx context.Context, event *models.AuditEvent) error
+	List(ctx context.Context, filter *models.AuditFilter, pq *utils.PaginationQuery) (*models.AuditEventsList, error)
+}
@@ -0,0 +1,18 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/JamesHsu333/go-grpc/internal/models"
+)
+
+// User changes repository interface, changes are recorded by users table trigger
+type ChangesRepository interface {
+	Listen(ctx context.Context)
+	Subscribe() (<-chan struct{}, func())
+	CurrentToken(ctx context.Context) (string, error)
+	CheckToken(resumeToken string) error
+	ListChanges(ctx context.Context, resumeToken string, types []string, limit int) ([]*models.UserChange, error)
+	DeleteChangesBefore(ctx context.Context, before time.Time) (int64, error)
+}
@@ -0,0 +1,117 @@
+package grpc
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JamesHsu333/go-grpc/internal/models"
+	userProto "github.com/JamesHsu333/go-grpc/proto/user"
+)
+
+const (
+	defaultExportChunkSize = 500
+	maxExportChunkSize     = 5000
+)
+
+// Columns of csv export, password is never exported
+var exportCSVHeader = []string{
+	"user_id", "first_name", "last_name", "email", "email_verified", "role", "about", "avatar", "phone_number",
+	"address", "city", "country", "gender", "postcode", "birthday", "created_at", "updated_at", "login_date",
+	"deleted_at", "version",
+}
+
+// Encode chunk of exported users into response, csv header is written with the first chunk
+func (u *usersService) exportChunkToProto(format userProto.ExportFormat, users []*models.User, first bool) (*userProto.ExportUsersResponse, error) {
+	switch format {
+	case userProto.ExportFormat_EXPORT_FORMAT_CSV:
+		buf := &bytes.Buffer{}
+		w := csv.NewWriter(buf)
+		if first {
+			if err := w.Write(exportCSVHeader); err != nil {
+				return nil, err
+			}
+		}
+		for _, user := range users {
+			if err := w.Write(exportCSVRecord(user)); err != nil {
+				return nil, err
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return nil, err
+		}
+		return &userProto.ExportUsersResponse{Data: buf.Bytes()}, nil
+
+	case userProto.ExportFormat_EXPORT_FORMAT_JSONL:
+		buf := &bytes.Buffer{}
+		encoder := json.NewEncoder(buf)
+		for _, user := range users {
+			user.SanitizePassword()
+			if err := encoder.Encode(user); err != nil {
+				return nil, err
+			}
+		}
+		return &userProto.ExportUsersResponse{Data: buf.Bytes()}, nil
+
+	default:
+		usersProto := make([]*userProto.User, 0, len(users))
+		for _, user := range users {
+			user.SanitizePassword()
+			usersProto = append(usersProto, u.userModelToProto(user))
+		}
+		return &userProto.ExportUsersResponse{Users: usersProto}, nil
+	}
+}
+
+func exportCSVRecord(user *models.User) []string {
+	var postcode, birthday, deletedAt string
+	if user.Postcode != nil {
+		postcode = strconv.Itoa(*user.Postcode)
+	}
+	if user.Birthday != nil {
+		birthday = user.Birthday.Format("2006-01-02")
+	}
+	if user.DeletedAt != nil {
+		deletedAt = user.DeletedAt.Format(time.RFC3339)
+	}
+
+	record := []string{
+		user.UserID.String(),
+		user.FirstName,
+		user.LastName,
+		user.Email,
+		strconv.FormatBool(user.EmailVerified),
+		user.GetRole(),
+		user.GetAbout(),
+		user.GetAvatar(),
+		user.GetPhoneNumber(),
+		user.GetAddress(),
+		user.GetCity(),
+		user.GetCountry(),
+		user.GetGender(),
+		postcode,
+		birthday,
+		user.CreatedAt.Format(time.RFC3339),
+		user.UpdatedAt.Format(time.RFC3339),
+		user.LoginDate.Format(time.RFC3339),
+		deletedAt,
+		strconv.FormatInt(user.Version, 10),
+	}
+	for i, value := range record {
+		record[i] = escapeCSVValue(value)
+	}
+
+	return record
+}
+
+// Prefix value spreadsheets would evaluate as formula with quote, so it is shown as text
+func escapeCSVValue(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -1,14 +1,16 @@
 package grpc
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	"github.com/opentracing/opentracing-go"
-	"github.com/pkg/errors"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
@@ -28,7 +30,7 @@ func (u *usersService) Register(ctx context.Context, r *userProto.RegisterReques
 		Email:     r.GetEmail(),
 		FirstName: r.GetFirstName(),
 		LastName:  r.GetLastName(),
-		Password:  r.GetFirstName(),
+		Password:  r.GetPassword(),
 		Gender:    &gender,
 	}
 
@@ -58,21 +60,128 @@ func (u *usersService) Login(ctx context.Context, r *userProto.LoginRequest) (*u
 		return nil, status.Errorf(codes.InvalidArgument, "ValidateEmail: %v", email)
 	}
 
-	user, err := u.userUC.Login(ctx, email, r.GetPassword())
+	user, err := u.userUC.Login(ctx, email, r.GetPassword(), utils.GetClientIP(ctx, u.cfg.Server.TrustedProxies))
 	if err != nil {
 		u.logger.Errorf("userUC.Login: %v", err)
-		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "Login: %v", err)
+		return nil, grpc_errors.ErrorStatus(err, "Login: %v", err).Err()
 	}
 
-	session, err := u.sessUC.CreateSession(ctx, &models.Session{
-		UserID: user.UserID,
-	}, u.cfg.Session.Expire)
+	mfaToken, err := u.userUC.CreateMFAChallenge(ctx, user.UserID)
 	if err != nil {
-		u.logger.Errorf("sessUC.CreateSession: %v", err)
-		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "sessUC.CreateSession: %v", err)
+		u.logger.Errorf("userUC.CreateMFAChallenge: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.CreateMFAChallenge: %v", err)
+	}
+	if mfaToken != "" {
+		return &userProto.LoginResponse{MfaRequired: true, MfaToken: mfaToken}, nil
+	}
+
+	return u.createLoginResponse(ctx, user, r.GetIssueTokens())
+}
+
+// Complete login of user with enabled MFA
+func (u *usersService) VerifyMFA(ctx context.Context, r *userProto.VerifyMFARequest) (*userProto.LoginResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.VerifyMFA")
+	defer span.Finish()
+
+	user, err := u.userUC.VerifyMFA(ctx, r.GetMfaToken(), r.GetCode(), utils.GetClientIP(ctx, u.cfg.Server.TrustedProxies))
+	if err != nil {
+		u.logger.Errorf("userUC.VerifyMFA: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.VerifyMFA: %v", err)
+	}
+
+	return u.createLoginResponse(ctx, user, r.GetIssueTokens())
+}
+
+// Start TOTP enrollment for current user
+func (u *usersService) EnrollTOTP(ctx context.Context, r *userProto.EnrollTOTPRequest) (*userProto.EnrollTOTPResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.EnrollTOTP")
+	defer span.Finish()
+
+	session, err := utils.GetSessionFromCtx(ctx)
+	if err != nil {
+		u.logger.Errorf("utils.GetSessionFromCtx: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "utils.GetSessionFromCtx: %v", err)
+	}
+
+	enrollment, err := u.userUC.EnrollTOTP(ctx, session.UserID)
+	if err != nil {
+		u.logger.Errorf("userUC.EnrollTOTP: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.EnrollTOTP: %v", err)
+	}
+
+	return &userProto.EnrollTOTPResponse{
+		Secret:        enrollment.Secret,
+		OtpauthUrl:    enrollment.URL,
+		RecoveryCodes: enrollment.RecoveryCodes,
+	}, nil
+}
+
+// Confirm TOTP enrollment of current user
+func (u *usersService) ConfirmTOTP(ctx context.Context, r *userProto.ConfirmTOTPRequest) (*userProto.ConfirmTOTPResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.ConfirmTOTP")
+	defer span.Finish()
+
+	session, err := utils.GetSessionFromCtx(ctx)
+	if err != nil {
+		u.logger.Errorf("utils.GetSessionFromCtx: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "utils.GetSessionFromCtx: %v", err)
+	}
+
+	if err := u.userUC.ConfirmTOTP(ctx, session.UserID, r.GetCode()); err != nil {
+		u.logger.Errorf("userUC.ConfirmTOTP: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.ConfirmTOTP: %v", err)
+	}
+
+	return &userProto.ConfirmTOTPResponse{}, nil
+}
+
+// Disable TOTP of current user
+func (u *usersService) DisableTOTP(ctx context.Context, r *userProto.DisableTOTPRequest) (*userProto.DisableTOTPResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.DisableTOTP")
+	defer span.Finish()
+
+	session, err := utils.GetSessionFromCtx(ctx)
+	if err != nil {
+		u.logger.Errorf("utils.GetSessionFromCtx: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "utils.GetSessionFromCtx: %v", err)
+	}
+
+	if err := u.userUC.DisableTOTP(ctx, session.UserID, r.GetCode()); err != nil {
+		u.logger.Errorf("userUC.DisableTOTP: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.DisableTOTP: %v", err)
+	}
+
+	return &userProto.DisableTOTPResponse{}, nil
+}
+
+// Rotate refresh token and issue new access token
+func (u *usersService) RefreshToken(ctx context.Context, r *userProto.RefreshTokenRequest) (*userProto.RefreshTokenResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.RefreshToken")
+	defer span.Finish()
+
+	session, refreshToken, err := u.sessUC.RefreshToken(ctx, r.GetRefreshToken(), u.cfg.Jwt.RefreshTokenExpire)
+	if err != nil {
+		u.logger.Errorf("sessUC.RefreshToken: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "sessUC.RefreshToken: %v", err)
+	}
+
+	user, err := u.userUC.GetByID(ctx, session.UserID)
+	if err != nil {
+		u.logger.Errorf("userUC.GetByID: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.GetByID: %v", err)
 	}
 
-	return &userProto.LoginResponse{User: u.userModelToProto(user), SessionId: session}, err
+	accessToken, expiresAt, err := utils.GenerateJWTToken(user, session.SessionID, u.cfg)
+	if err != nil {
+		u.logger.Errorf("utils.GenerateJWTToken: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "utils.GenerateJWTToken: %v", err)
+	}
+
+	return &userProto.RefreshTokenResponse{
+		AccessToken:          accessToken,
+		RefreshToken:         refreshToken,
+		AccessTokenExpiresAt: timestamppb.New(expiresAt),
+	}, nil
 }
 
 // Find user by uuid
@@ -95,33 +204,52 @@ func (u *usersService) GetUserByID(ctx context.Context, r *userProto.GetUserByID
 	return &userProto.GetUserByIDResponse{User: u.userModelToProto(user)}, nil
 }
 
-// Get session id from, ctx metadata, find user by uuid and returns it
-func (u *usersService) GetMe(ctx context.Context, r *userProto.GetMeRequest) (*userProto.GetMeResponse, error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.GetMe")
+// Find users by uuids, results keep request order and mark users not found
+func (u *usersService) BatchGetUsers(ctx context.Context, r *userProto.BatchGetUsersRequest) (*userProto.BatchGetUsersResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.BatchGetUsers")
 	defer span.Finish()
 
-	sessID, err := u.getSessionIDFromCtx(ctx)
-	if err != nil {
-		u.logger.Errorf("getSessionIDFromCtx: %v", err)
-		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "sessUC.getSessionIDFromCtx: %v", err)
+	userIDs := make([]uuid.UUID, 0, len(r.GetUserIds()))
+	for _, id := range r.GetUserIds() {
+		userID, err := uuid.Parse(id)
+		if err != nil {
+			u.logger.Errorf("uuid.Parse: %v", err)
+			return nil, status.Errorf(codes.InvalidArgument, "uuid.Parse: %v", err)
+		}
+		userIDs = append(userIDs, userID)
 	}
 
-	session, err := u.sessUC.GetSessionByID(ctx, sessID)
+	users, err := u.userUC.BatchGetUsers(ctx, userIDs)
 	if err != nil {
-		u.logger.Errorf("sessUC.GetSessionByID: %v", err)
-		if errors.Is(err, redis.Nil) {
-			return nil, status.Errorf(codes.NotFound, "sessUC.GetSessionByID: %v", grpc_errors.ErrNotFound)
+		u.logger.Errorf("userUC.BatchGetUsers: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.BatchGetUsers: %v", err)
+	}
+
+	results := make([]*userProto.BatchGetUsersResult, 0, len(users))
+	for i, user := range users {
+		result := &userProto.BatchGetUsersResult{UserId: userIDs[i].String()}
+		if user != nil {
+			result.Found = true
+			result.User = u.userModelToProto(user)
 		}
-		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "sessUC.GetSessionByID: %v", err)
+		results = append(results, result)
 	}
 
-	userUUID, err := uuid.Parse(session.UserID.String())
+	return &userProto.BatchGetUsersResponse{Results: results}, nil
+}
+
+// Get authenticated session from ctx, find user by uuid and returns it
+func (u *usersService) GetMe(ctx context.Context, r *userProto.GetMeRequest) (*userProto.GetMeResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.GetMe")
+	defer span.Finish()
+
+	session, err := utils.GetSessionFromCtx(ctx)
 	if err != nil {
-		u.logger.Errorf("uuid.Parse: %v", err)
-		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "uuid.Parse: %v", err)
+		u.logger.Errorf("utils.GetSessionFromCtx: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "utils.GetSessionFromCtx: %v", err)
 	}
 
-	user, err := u.userUC.GetByID(ctx, userUUID)
+	user, err := u.userUC.GetByID(ctx, session.UserID)
 	if err != nil {
 		u.logger.Errorf("userUC.FindById: %v", err)
 		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.FindById: %v", err)
@@ -135,15 +263,15 @@ func (u *usersService) Logout(ctx context.Context, r *userProto.LogoutRequest) (
 	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.Logout")
 	defer span.Finish()
 
-	sessID, err := u.getSessionIDFromCtx(ctx)
+	session, err := utils.GetSessionFromCtx(ctx)
 	if err != nil {
-		u.logger.Errorf("getSessionIDFromCtx: %v", err)
-		return nil, err
+		u.logger.Errorf("utils.GetSessionFromCtx: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "utils.GetSessionFromCtx: %v", err)
 	}
 
-	if err := u.sessUC.DeleteByID(ctx, sessID); err != nil {
-		u.logger.Errorf("sessUC.DeleteByID: %v", err)
-		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "sessUC.DeleteByID: %v", err)
+	if err := u.userUC.Logout(ctx, session); err != nil {
+		u.logger.Errorf("userUC.Logout: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.Logout: %v", err)
 	}
 
 	return &userProto.LogoutResponse{}, nil
@@ -160,37 +288,51 @@ func (u *usersService) Update(ctx context.Context, r *userProto.UpdateRequest) (
 		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "uuid.Parse: %v", err)
 	}
 
-	about := r.User.GetAbout()
-	avatar := r.User.GetAvatar()
-	phoneNumber := r.User.GetPhoneNumber()
-	address := r.User.GetAddress()
-	city := r.User.GetCity()
-	country := r.User.GetCountry()
-	gender := r.User.GetGender()
 	postcode := int(r.User.GetPostcode())
-	birthday := r.User.GetBirthday().AsTime()
-
 	user := &models.User{
 		UserID:      userID,
 		FirstName:   r.User.GetFirstName(),
 		LastName:    r.User.GetLastName(),
-		Email:       r.User.GetEmail(),
-		About:       &about,
-		Avatar:      &avatar,
-		PhoneNumber: &phoneNumber,
-		Address:     &address,
-		City:        &city,
-		Country:     &country,
-		Gender:      &gender,
+		Email:       strings.ToLower(strings.TrimSpace(r.User.GetEmail())),
+		About:       optionalString(r.User.GetAbout()),
+		PhoneNumber: optionalString(strings.TrimSpace(r.User.GetPhoneNumber())),
+		Address:     optionalString(r.User.GetAddress()),
+		City:        optionalString(r.User.GetCity()),
+		Country:     optionalString(r.User.GetCountry()),
+		Gender:      optionalString(r.User.GetGender()),
 		Postcode:    &postcode,
-		Birthday:    &birthday,
+		Version:     r.GetExpectedVersion(),
+	}
+	if r.User.GetBirthday() != nil {
+		birthday := r.User.GetBirthday().AsTime()
+		user.Birthday = &birthday
+	}
+
+	fields := r.GetUpdateMask().GetPaths()
+	if len(fields) == 0 {
+		fields = userUpdateFields(r.GetUser())
+	}
+
+	structFields := make([]string, 0, len(fields))
+	for _, field := range fields {
+		structField, ok := models.UserUpdateMaskFields[field]
+		if !ok {
+			u.logger.Errorf("UpdateMask: unknown field %q", field)
+			return nil, status.Errorf(codes.InvalidArgument, "UpdateMask: %v: unknown field %q", grpc_errors.ErrInvalidMask, field)
+		}
+		structFields = append(structFields, structField)
 	}
 
-	updatedUser, err := u.userUC.Update(ctx, user)
+	if err := utils.ValidateStructPartial(ctx, user, structFields...); err != nil {
+		u.logger.Errorf("ValidateStructPartial: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "ValidateStructPartial: %v", err)
+	}
+
+	updatedUser, err := u.userUC.Update(ctx, user, fields)
 
 	if err != nil {
 		u.logger.Errorf("userUC.Update: %v", err)
-		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.Update: %v", err)
+		return nil, grpc_errors.ErrorStatus(err, "userUC.Update: %v", err).Err()
 	}
 
 	return &userProto.UpdateResponse{User: u.userModelToProto(updatedUser)}, nil
@@ -210,15 +352,16 @@ func (u *usersService) UpdateRole(ctx context.Context, r *userProto.UpdateRoleRe
 	role := r.User.GetRole()
 
 	user := &models.User{
-		UserID: userID,
-		Role:   &role,
+		UserID:  userID,
+		Role:    &role,
+		Version: r.GetExpectedVersion(),
 	}
 
 	updatedUser, err := u.userUC.UpdateRole(ctx, user)
 
 	if err != nil {
 		u.logger.Errorf("userUC.UpdateRole: %v", err)
-		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.UpdateRole: %v", err)
+		return nil, grpc_errors.ErrorStatus(err, "userUC.UpdateRole: %v", err).Err()
 	}
 
 	return &userProto.UpdateRoleResponse{User: u.userModelToProto(updatedUser)}, nil
@@ -245,6 +388,26 @@ func (u *usersService) Delete(ctx context.Context, r *userProto.DeleteRequest) (
 	return &userProto.DeleteResponse{}, nil
 }
 
+// Restore soft deleted user
+func (u *usersService) RestoreUser(ctx context.Context, r *userProto.RestoreUserRequest) (*userProto.RestoreUserResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.RestoreUser")
+	defer span.Finish()
+
+	userID, err := uuid.Parse(r.GetUserId())
+	if err != nil {
+		u.logger.Errorf("uuid.Parse: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "uuid.Parse: %v", err)
+	}
+
+	user, err := u.userUC.Restore(ctx, userID)
+	if err != nil {
+		u.logger.Errorf("userUC.Restore: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.Restore: %v", err)
+	}
+
+	return &userProto.RestoreUserResponse{User: u.userModelToProto(user)}, nil
+}
+
 // Find users by name
 func (u *usersService) FindByName(ctx context.Context, r *userProto.FindByNameRequest) (*userProto.FindByNameResponse, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.FindByName")
@@ -258,9 +421,16 @@ func (u *usersService) FindByName(ctx context.Context, r *userProto.FindByNameRe
 	}
 
 	pq := &utils.PaginationQuery{
-		Size:    size,
-		Page:    int(r.Pagination.GetPage()),
-		OrderBy: r.Pagination.GetOrderby(),
+		Size:           size,
+		Page:           int(r.Pagination.GetPage()),
+		OrderBy:        r.Pagination.GetOrderby(),
+		PageToken:      r.Pagination.GetPageToken(),
+		SkipTotalCount: r.Pagination.GetSkipTotalCount(),
+	}
+
+	if err := pq.Validate(); err != nil {
+		u.logger.Errorf("PaginationQuery.Validate: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "PaginationQuery.Validate: %v", err)
 	}
 
 	users, err := u.userUC.FindByName(ctx, r.GetName(), pq)
@@ -273,6 +443,56 @@ func (u *usersService) FindByName(ctx context.Context, r *userProto.FindByNameRe
 	return &userProto.FindByNameResponse{Users: u.userListModelToProto(users)}, nil
 }
 
+// Search users by name, email, city and about, ranked by relevance
+func (u *usersService) SearchUsers(ctx context.Context, r *userProto.SearchUsersRequest) (*userProto.SearchUsersResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.SearchUsers")
+	defer span.Finish()
+
+	if strings.TrimSpace(r.GetQuery()) == "" {
+		u.logger.Errorf("SearchUsers: empty query")
+		return nil, status.Errorf(codes.InvalidArgument, "SearchUsers: empty query")
+	}
+
+	var size int
+	if r.Pagination.GetSize() == 0 {
+		size = 10
+	} else {
+		size = int(r.Pagination.GetSize())
+	}
+
+	pq := &utils.PaginationQuery{
+		Size: size,
+		Page: int(r.Pagination.GetPage()),
+	}
+
+	if err := pq.Validate(); err != nil {
+		u.logger.Errorf("PaginationQuery.Validate: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "PaginationQuery.Validate: %v", err)
+	}
+
+	results, err := u.userUC.SearchUsers(ctx, r.GetQuery(), pq)
+	if err != nil {
+		u.logger.Errorf("userUC.SearchUsers: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.SearchUsers: %v", err)
+	}
+
+	res := &userProto.SearchUsersResponse{
+		Page:    int32(results.Page),
+		Size:    int32(results.Size),
+		HasMore: results.HasMore,
+		Results: make([]*userProto.SearchResult, 0, len(results.Results)),
+	}
+	for _, result := range results.Results {
+		res.Results = append(res.Results, &userProto.SearchResult{
+			User:      u.userModelToProto(&result.User),
+			Rank:      float32(result.Rank),
+			Highlight: result.Highlight,
+		})
+	}
+
+	return res, nil
+}
+
 // Get users
 func (u *usersService) GetUsers(ctx context.Context, r *userProto.GetUsersRequest) (*userProto.GetUsersResponse, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.GetUsers")
@@ -286,12 +506,25 @@ func (u *usersService) GetUsers(ctx context.Context, r *userProto.GetUsersReques
 	}
 
 	pq := &utils.PaginationQuery{
-		Size:    size,
-		Page:    int(r.Pagination.GetPage()),
-		OrderBy: r.Pagination.GetOrderby(),
+		Size:           size,
+		Page:           int(r.Pagination.GetPage()),
+		OrderBy:        r.Pagination.GetOrderby(),
+		PageToken:      r.Pagination.GetPageToken(),
+		SkipTotalCount: r.Pagination.GetSkipTotalCount(),
+	}
+
+	if err := pq.Validate(); err != nil {
+		u.logger.Errorf("PaginationQuery.Validate: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "PaginationQuery.Validate: %v", err)
+	}
+
+	filter, err := u.userFilterProtoToModel(r.GetFilter())
+	if err != nil {
+		u.logger.Errorf("userFilterProtoToModel: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "userFilterProtoToModel: %v", err)
 	}
 
-	users, err := u.userUC.GetUsers(ctx, pq)
+	users, err := u.userUC.GetUsers(ctx, filter, pq)
 
 	if err != nil {
 		u.logger.Errorf("userUC.GetUsers: %v", err)
@@ -301,38 +534,517 @@ func (u *usersService) GetUsers(ctx context.Context, r *userProto.GetUsersReques
 	return &userProto.GetUsersResponse{Users: u.userListModelToProto(users)}, nil
 }
 
-func (u *usersService) getSessionIDFromCtx(ctx context.Context) (string, error) {
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return "", status.Errorf(codes.Unauthenticated, "metadata.FromIncomingContext: %v", grpc_errors.ErrNoCtxMetaData)
+// Import users streamed in chunks of rows, responds with report of every row; dry run only validates rows
+func (u *usersService) ImportUsers(stream userProto.UserService_ImportUsersServer) error {
+	span, ctx := opentracing.StartSpanFromContext(stream.Context(), "usersService.ImportUsers")
+	defer span.Finish()
+
+	var dryRun bool
+	rows := make([]*models.ImportRow, 0)
+	for first := true; ; first = false {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			u.logger.Errorf("stream.Recv: %v", err)
+			return status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "stream.Recv: %v", err)
+		}
+		if first {
+			dryRun = req.GetDryRun()
+		}
+
+		if len(rows)+len(req.GetRows()) > maxImportRows {
+			u.logger.Errorf("ImportUsers: %v", grpc_errors.ErrBatchTooLarge)
+			return status.Errorf(codes.InvalidArgument, "ImportUsers: %v, max %d rows", grpc_errors.ErrBatchTooLarge, maxImportRows)
+		}
+		for _, row := range req.GetRows() {
+			rows = append(rows, importRowProtoToModel(row))
+		}
+	}
+
+	results, err := u.userUC.ImportUsers(ctx, rows, dryRun)
+	if err != nil {
+		u.logger.Errorf("userUC.ImportUsers: %v", err)
+		return status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.ImportUsers: %v", err)
+	}
+
+	return stream.SendAndClose(importResultsModelToProto(results, dryRun))
+}
+
+// Stream users matching filter in chunks of requested format, rows come from a single database snapshot
+func (u *usersService) ExportUsers(r *userProto.ExportUsersRequest, stream userProto.UserService_ExportUsersServer) error {
+	span, ctx := opentracing.StartSpanFromContext(stream.Context(), "usersService.ExportUsers")
+	defer span.Finish()
+
+	if _, ok := userProto.ExportFormat_name[int32(r.GetFormat())]; !ok {
+		u.logger.Errorf("ExportUsers: unknown format %v", r.GetFormat())
+		return status.Errorf(codes.InvalidArgument, "ExportUsers: unknown format %v", r.GetFormat())
+	}
+
+	filter, err := u.userFilterProtoToModel(r.GetFilter())
+	if err != nil {
+		u.logger.Errorf("userFilterProtoToModel: %v", err)
+		return status.Errorf(codes.InvalidArgument, "userFilterProtoToModel: %v", err)
+	}
+
+	chunkSize := int(r.GetChunkSize())
+	if chunkSize <= 0 || chunkSize > maxExportChunkSize {
+		chunkSize = defaultExportChunkSize
+	}
+
+	chunk := make([]*models.User, 0, chunkSize)
+	sent := false
+	send := func() error {
+		res, err := u.exportChunkToProto(r.GetFormat(), chunk, !sent)
+		if err != nil {
+			return err
+		}
+		if err = stream.Send(res); err != nil {
+			return err
+		}
+		chunk = chunk[:0]
+		sent = true
+		return nil
+	}
+
+	if err = u.userUC.ExportUsers(ctx, filter, func(user *models.User) error {
+		chunk = append(chunk, user)
+		if len(chunk) < chunkSize {
+			return nil
+		}
+		return send()
+	}); err != nil {
+		u.logger.Errorf("userUC.ExportUsers: %v", err)
+		return status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.ExportUsers: %v", err)
+	}
+
+	if len(chunk) > 0 || !sent {
+		if err = send(); err != nil {
+			u.logger.Errorf("ExportUsers.send: %v", err)
+			return status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "ExportUsers.send: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Stream user changes as they are committed until client disconnects
+func (u *usersService) WatchUsers(r *userProto.WatchUsersRequest, stream userProto.UserService_WatchUsersServer) error {
+	span, ctx := opentracing.StartSpanFromContext(stream.Context(), "usersService.WatchUsers")
+	defer span.Finish()
+
+	if err := u.userUC.WatchUsers(ctx, r.GetResumeToken(), r.GetTypes(), func(change *models.UserChange) error {
+		res := &userProto.WatchUsersResponse{
+			Type:        change.Type,
+			UserId:      change.UserID.String(),
+			ChangedAt:   timestamppb.New(change.ChangedAt),
+			ResumeToken: change.ResumeToken,
+		}
+		if change.User != nil {
+			res.User = u.userModelToProto(change.User)
+		}
+		return stream.Send(res)
+	}); err != nil {
+		u.logger.Errorf("userUC.WatchUsers: %v", err)
+		return status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.WatchUsers: %v", err)
+	}
+
+	return nil
+}
+
+// List audit events of user mutations, newest first
+func (u *usersService) ListAuditEvents(ctx context.Context, r *userProto.ListAuditEventsRequest) (*userProto.ListAuditEventsResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.ListAuditEvents")
+	defer span.Finish()
+
+	filter := &models.AuditFilter{Actions: r.GetActions()}
+	if r.GetActorId() != "" {
+		actorID, err := uuid.Parse(r.GetActorId())
+		if err != nil {
+			u.logger.Errorf("uuid.Parse: %v", err)
+			return nil, status.Errorf(codes.InvalidArgument, "uuid.Parse: %v", err)
+		}
+		filter.ActorID = &actorID
+	}
+	if r.GetTargetId() != "" {
+		targetID, err := uuid.Parse(r.GetTargetId())
+		if err != nil {
+			u.logger.Errorf("uuid.Parse: %v", err)
+			return nil, status.Errorf(codes.InvalidArgument, "uuid.Parse: %v", err)
+		}
+		filter.TargetID = &targetID
+	}
+	if r.GetCreatedAt().GetFrom() != nil {
+		from := r.GetCreatedAt().GetFrom().AsTime()
+		filter.CreatedAt.From = &from
+	}
+	if r.GetCreatedAt().GetTo() != nil {
+		to := r.GetCreatedAt().GetTo().AsTime()
+		filter.CreatedAt.To = &to
+	}
+
+	var size int
+	if r.Pagination.GetSize() == 0 {
+		size = 10
+	} else {
+		size = int(r.Pagination.GetSize())
+	}
+
+	pq := &utils.PaginationQuery{
+		Size: size,
+		Page: int(r.Pagination.GetPage()),
+	}
+
+	if err := pq.Validate(); err != nil {
+		u.logger.Errorf("PaginationQuery.Validate: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "PaginationQuery.Validate: %v", err)
+	}
+
+	events, err := u.userUC.ListAuditEvents(ctx, filter, pq)
+	if err != nil {
+		u.logger.Errorf("userUC.ListAuditEvents: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.ListAuditEvents: %v", err)
+	}
+
+	res := &userProto.ListAuditEventsResponse{
+		Page:    int32(events.Page),
+		Size:    int32(events.Size),
+		HasMore: events.HasMore,
+		Events:  make([]*userProto.AuditEvent, 0, len(events.Events)),
+	}
+	for _, event := range events.Events {
+		res.Events = append(res.Events, u.auditEventModelToProto(event))
+	}
+
+	return res, nil
+}
+
+// Change password of authenticated user, all user sessions are revoked
+func (u *usersService) ChangePassword(ctx context.Context, r *userProto.ChangePasswordRequest) (*userProto.ChangePasswordResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.ChangePassword")
+	defer span.Finish()
+
+	session, err := utils.GetSessionFromCtx(ctx)
+	if err != nil {
+		u.logger.Errorf("utils.GetSessionFromCtx: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "utils.GetSessionFromCtx: %v", err)
+	}
+
+	if !utils.ValidatePassword(r.GetNewPassword()) {
+		u.logger.Errorf("ValidatePassword: invalid new password")
+		return nil, status.Errorf(codes.InvalidArgument, "ValidatePassword: invalid new password")
+	}
+
+	if err := u.userUC.ChangePassword(ctx, session.UserID, r.GetCurrentPassword(), r.GetNewPassword()); err != nil {
+		u.logger.Errorf("userUC.ChangePassword: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.ChangePassword: %v", err)
+	}
+
+	return &userProto.ChangePasswordResponse{}, nil
+}
+
+// Send password reset token to user email
+func (u *usersService) RequestPasswordReset(ctx context.Context, r *userProto.RequestPasswordResetRequest) (*userProto.RequestPasswordResetResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.RequestPasswordReset")
+	defer span.Finish()
+
+	email := r.GetEmail()
+
+	if !utils.ValidateEmail(email) {
+		u.logger.Errorf("ValidateEmail: %v", email)
+		return nil, status.Errorf(codes.InvalidArgument, "ValidateEmail: %v", email)
+	}
+
+	if err := u.userUC.RequestPasswordReset(ctx, email); err != nil {
+		u.logger.Errorf("userUC.RequestPasswordReset: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.RequestPasswordReset: %v", err)
+	}
+
+	return &userProto.RequestPasswordResetResponse{}, nil
+}
+
+// Reset password with token, all user sessions are revoked
+func (u *usersService) ConfirmPasswordReset(ctx context.Context, r *userProto.ConfirmPasswordResetRequest) (*userProto.ConfirmPasswordResetResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.ConfirmPasswordReset")
+	defer span.Finish()
+
+	if !utils.ValidatePassword(r.GetNewPassword()) {
+		u.logger.Errorf("ValidatePassword: invalid new password")
+		return nil, status.Errorf(codes.InvalidArgument, "ValidatePassword: invalid new password")
+	}
+
+	if err := u.userUC.ConfirmPasswordReset(ctx, r.GetToken(), r.GetNewPassword()); err != nil {
+		u.logger.Errorf("userUC.ConfirmPasswordReset: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.ConfirmPasswordReset: %v", err)
+	}
+
+	return &userProto.ConfirmPasswordResetResponse{}, nil
+}
+
+// Verify user email with token
+func (u *usersService) VerifyEmail(ctx context.Context, r *userProto.VerifyEmailRequest) (*userProto.VerifyEmailResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.VerifyEmail")
+	defer span.Finish()
+
+	user, err := u.userUC.VerifyEmail(ctx, r.GetToken())
+	if err != nil {
+		u.logger.Errorf("userUC.VerifyEmail: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.VerifyEmail: %v", err)
+	}
+
+	return &userProto.VerifyEmailResponse{User: u.userModelToProto(user)}, nil
+}
+
+// Send new email verification token
+func (u *usersService) ResendVerification(ctx context.Context, r *userProto.ResendVerificationRequest) (*userProto.ResendVerificationResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.ResendVerification")
+	defer span.Finish()
+
+	email := r.GetEmail()
+
+	if !utils.ValidateEmail(email) {
+		u.logger.Errorf("ValidateEmail: %v", email)
+		return nil, status.Errorf(codes.InvalidArgument, "ValidateEmail: %v", email)
+	}
+
+	if err := u.userUC.ResendVerification(ctx, email); err != nil {
+		u.logger.Errorf("userUC.ResendVerification: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.ResendVerification: %v", err)
+	}
+
+	return &userProto.ResendVerificationResponse{}, nil
+}
+
+// List active sessions of authenticated user
+func (u *usersService) ListMySessions(ctx context.Context, r *userProto.ListMySessionsRequest) (*userProto.ListMySessionsResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.ListMySessions")
+	defer span.Finish()
+
+	session, err := utils.GetSessionFromCtx(ctx)
+	if err != nil {
+		u.logger.Errorf("utils.GetSessionFromCtx: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "utils.GetSessionFromCtx: %v", err)
+	}
+
+	sessions, err := u.sessUC.GetSessionsByUserID(ctx, session.UserID)
+	if err != nil {
+		u.logger.Errorf("sessUC.GetSessionsByUserID: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "sessUC.GetSessionsByUserID: %v", err)
+	}
+
+	return &userProto.ListMySessionsResponse{Sessions: u.sessionsModelToProto(sessions, session.SessionID)}, nil
+}
+
+// Revoke one of authenticated user sessions
+func (u *usersService) RevokeSession(ctx context.Context, r *userProto.RevokeSessionRequest) (*userProto.RevokeSessionResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.RevokeSession")
+	defer span.Finish()
+
+	session, err := utils.GetSessionFromCtx(ctx)
+	if err != nil {
+		u.logger.Errorf("utils.GetSessionFromCtx: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "utils.GetSessionFromCtx: %v", err)
+	}
+
+	if err := u.sessUC.DeleteUserSession(ctx, session.UserID, r.GetSessionId()); err != nil {
+		u.logger.Errorf("sessUC.DeleteUserSession: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "sessUC.DeleteUserSession: %v", err)
+	}
+
+	return &userProto.RevokeSessionResponse{}, nil
+}
+
+// Revoke all sessions of authenticated user, including current one
+func (u *usersService) RevokeAllSessions(ctx context.Context, r *userProto.RevokeAllSessionsRequest) (*userProto.RevokeAllSessionsResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.RevokeAllSessions")
+	defer span.Finish()
+
+	session, err := utils.GetSessionFromCtx(ctx)
+	if err != nil {
+		u.logger.Errorf("utils.GetSessionFromCtx: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "utils.GetSessionFromCtx: %v", err)
+	}
+
+	if err := u.sessUC.DeleteByUserID(ctx, session.UserID); err != nil {
+		u.logger.Errorf("sessUC.DeleteByUserID: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "sessUC.DeleteByUserID: %v", err)
+	}
+
+	return &userProto.RevokeAllSessionsResponse{}, nil
+}
+
+// List active sessions of any user
+func (u *usersService) ListUserSessions(ctx context.Context, r *userProto.ListUserSessionsRequest) (*userProto.ListUserSessionsResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.ListUserSessions")
+	defer span.Finish()
+
+	userID, err := uuid.Parse(r.GetUserId())
+	if err != nil {
+		u.logger.Errorf("uuid.Parse: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "uuid.Parse: %v", err)
 	}
 
-	sessionID := md.Get("session_id")
-	if sessionID[0] == "" {
-		return "", status.Errorf(codes.PermissionDenied, "md.Get sessionId: %v", grpc_errors.ErrInvalidSessionId)
+	sessions, err := u.sessUC.GetSessionsByUserID(ctx, userID)
+	if err != nil {
+		u.logger.Errorf("sessUC.GetSessionsByUserID: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "sessUC.GetSessionsByUserID: %v", err)
 	}
 
-	return sessionID[0], nil
+	return &userProto.ListUserSessionsResponse{Sessions: u.sessionsModelToProto(sessions, "")}, nil
+}
+
+// Revoke all sessions of any user
+func (u *usersService) RevokeUserSessions(ctx context.Context, r *userProto.RevokeUserSessionsRequest) (*userProto.RevokeUserSessionsResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.RevokeUserSessions")
+	defer span.Finish()
+
+	userID, err := uuid.Parse(r.GetUserId())
+	if err != nil {
+		u.logger.Errorf("uuid.Parse: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "uuid.Parse: %v", err)
+	}
+
+	if err := u.sessUC.DeleteByUserID(ctx, userID); err != nil {
+		u.logger.Errorf("sessUC.DeleteByUserID: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "sessUC.DeleteByUserID: %v", err)
+	}
+
+	return &userProto.RevokeUserSessionsResponse{}, nil
+}
+
+// Extend current session by idle timeout
+func (u *usersService) RefreshSession(ctx context.Context, r *userProto.RefreshSessionRequest) (*userProto.RefreshSessionResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.RefreshSession")
+	defer span.Finish()
+
+	session, err := utils.GetSessionFromCtx(ctx)
+	if err != nil {
+		u.logger.Errorf("utils.GetSessionFromCtx: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "utils.GetSessionFromCtx: %v", err)
+	}
+
+	refreshed, err := u.sessUC.RefreshSession(ctx, session.SessionID)
+	if err != nil {
+		u.logger.Errorf("sessUC.RefreshSession: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "sessUC.RefreshSession: %v", err)
+	}
+
+	return &userProto.RefreshSessionResponse{ExpiresAt: timestamppb.New(refreshed.ExpiresAt)}, nil
+}
+
+// Remove login lockout of account email and optionally client ip
+func (u *usersService) UnlockAccount(ctx context.Context, r *userProto.UnlockAccountRequest) (*userProto.UnlockAccountResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "usersService.UnlockAccount")
+	defer span.Finish()
+
+	email := r.GetEmail()
+
+	if email == "" && r.GetIpAddress() == "" {
+		u.logger.Errorf("UnlockAccount: email or ip address is required")
+		return nil, status.Errorf(codes.InvalidArgument, "UnlockAccount: email or ip address is required")
+	}
+
+	if email != "" && !utils.ValidateEmail(email) {
+		u.logger.Errorf("ValidateEmail: %v", email)
+		return nil, status.Errorf(codes.InvalidArgument, "ValidateEmail: %v", email)
+	}
+
+	if err := u.userUC.UnlockAccount(ctx, email, r.GetIpAddress()); err != nil {
+		u.logger.Errorf("userUC.UnlockAccount: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.UnlockAccount: %v", err)
+	}
+
+	return &userProto.UnlockAccountResponse{}, nil
+}
+
+// Upload user avatar, first message carries file info followed by file chunks
+func (u *usersService) UploadAvatar(stream userProto.UserService_UploadAvatarServer) error {
+	span, ctx := opentracing.StartSpanFromContext(stream.Context(), "usersService.UploadAvatar")
+	defer span.Finish()
+
+	session, err := utils.GetSessionFromCtx(ctx)
+	if err != nil {
+		u.logger.Errorf("utils.GetSessionFromCtx: %v", err)
+		return status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "utils.GetSessionFromCtx: %v", err)
+	}
+
+	req, err := stream.Recv()
+	if err != nil {
+		u.logger.Errorf("stream.Recv: %v", err)
+		return status.Errorf(codes.InvalidArgument, "stream.Recv: %v", err)
+	}
+
+	info := req.GetInfo()
+	if info == nil {
+		u.logger.Errorf("UploadAvatar: first message must contain avatar info")
+		return status.Errorf(codes.InvalidArgument, "UploadAvatar: first message must contain avatar info")
+	}
+
+	if info.GetUserId() != "" && info.GetUserId() != session.UserID.String() {
+		u.logger.Errorf("UploadAvatar: user %s can not upload avatar of user %s", session.UserID, info.GetUserId())
+		return status.Errorf(codes.PermissionDenied, "UploadAvatar: %v", grpc_errors.ErrPermissionDenied)
+	}
+
+	binaryImage := bytes.NewBuffer(nil)
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			u.logger.Errorf("stream.Recv: %v", err)
+			return status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "stream.Recv: %v", err)
+		}
+
+		chunk := req.GetChunk()
+		if int64(binaryImage.Len()+len(chunk)) > u.cfg.File.MaxAvatarSize {
+			u.logger.Errorf("UploadAvatar: %v", grpc_errors.ErrFileTooLarge)
+			return status.Errorf(codes.ResourceExhausted, "UploadAvatar: %v", grpc_errors.ErrFileTooLarge)
+		}
+		binaryImage.Write(chunk)
+	}
+
+	contentType, err := utils.CheckImageContentType(binaryImage.Bytes(), u.cfg.File.AllowedContentTypes)
+	if err != nil {
+		u.logger.Errorf("utils.CheckImageContentType: %v", err)
+		return status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "utils.CheckImageContentType: %v", err)
+	}
+
+	updatedUser, err := u.userUC.UploadAvatar(ctx, session.UserID, models.UploadInput{
+		File:        bytes.NewReader(binaryImage.Bytes()),
+		Name:        info.GetUploadInput().GetName(),
+		Size:        int64(binaryImage.Len()),
+		ContentType: contentType,
+	})
+	if err != nil {
+		u.logger.Errorf("userUC.UploadAvatar: %v", err)
+		return status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "userUC.UploadAvatar: %v", err)
+	}
+
+	return stream.SendAndClose(&userProto.UploadAvatarResponse{User: u.userModelToProto(updatedUser)})
 }
 
 func (u *usersService) userModelToProto(user *models.User) *userProto.User {
 	userProto := &userProto.User{
-		UserId:      user.UserID.String(),
-		FirstName:   user.FirstName,
-		LastName:    user.LastName,
-		Email:       user.Email,
-		Password:    user.Password,
-		Role:        user.GetRole(),
-		About:       user.GetAbout(),
-		Avatar:      user.GetAvatar(),
-		PhoneNumber: user.GetPhoneNumber(),
-		Address:     user.GetAddress(),
-		City:        user.GetCity(),
-		Country:     user.GetCountry(),
-		Gender:      user.GetGender(),
-		CreatedAt:   timestamppb.New(user.CreatedAt),
-		UpdatedAt:   timestamppb.New(user.UpdatedAt),
-		LoginDate:   timestamppb.New(user.LoginDate),
+		UserId:        user.UserID.String(),
+		FirstName:     user.FirstName,
+		LastName:      user.LastName,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Password:      user.Password,
+		Role:          user.GetRole(),
+		About:         user.GetAbout(),
+		Avatar:        user.GetAvatar(),
+		PhoneNumber:   user.GetPhoneNumber(),
+		Address:       user.GetAddress(),
+		City:          user.GetCity(),
+		Country:       user.GetCountry(),
+		Gender:        user.GetGender(),
+		CreatedAt:     timestamppb.New(user.CreatedAt),
+		UpdatedAt:     timestamppb.New(user.UpdatedAt),
+		LoginDate:     timestamppb.New(user.LoginDate),
+		Version:       user.Version,
 	}
 
 	if user.Birthday != nil {
@@ -343,6 +1055,10 @@ func (u *usersService) userModelToProto(user *models.User) *userProto.User {
 		userProto.Postcode = int32(*user.Postcode)
 	}
 
+	if user.DeletedAt != nil {
+		userProto.DeletedAt = timestamppb.New(*user.DeletedAt)
+	}
+
 	return userProto
 }
 
@@ -355,80 +1071,177 @@ func (u *usersService) userListModelToProto(users *models.UsersList) *userProto.
 	}
 
 	usersProto := &userProto.UsersList{
-		TotalCount: int32(users.TotalCount),
-		TotalPages: int32(users.TotalPages),
-		Page:       int32(users.Page),
-		Size:       int32(users.Size),
-		HasMore:    users.HasMore,
-		Users:      usersList,
+		TotalCount:    int32(users.TotalCount),
+		TotalPages:    int32(users.TotalPages),
+		Page:          int32(users.Page),
+		Size:          int32(users.Size),
+		HasMore:       users.HasMore,
+		NextPageToken: users.NextPageToken,
+		PrevPageToken: users.PrevPageToken,
+		Users:         usersList,
 	}
 
 	return usersProto
 }
 
-/*
-// UploadAvatar godoc
-// @Summary Post avatar
-// @Description Post user avatar image
-// @Tags Auth
-// @Accept  json
-// @Produce  json
-// @Param file formData file true "Body with image file"
-// @Param bucket query string true "aws s3 bucket" Format(bucket)
-// @Param id path int true "user_id"
-// @Success 200 {string} string	"ok"
-// @Failure 500 {object} httpErrors.RestError
-// @Router /auth/{id}/avatar [post]
-func (h *AuthHandlers) UploadAvatar() echo.HandlerFunc {
-	return func(c echo.Context) error {
-		span, ctx := opentracing.StartSpanFromContext(utils.GetRequestCtx(c), "AuthHandlers.UploadAvatar")
-		defer span.Finish()
-
-		uID, err := uuid.Parse(c.Param("user_id"))
-		if err != nil {
-			utils.LogResponseError(c, h.logger, err)
-			return c.JSON(httpErrors.ErrorResponse(err))
-		}
+func (u *usersService) sessionsModelToProto(sessions []*models.Session, currentSessionID string) []*userProto.SessionInfo {
+	sessionsProto := make([]*userProto.SessionInfo, 0, len(sessions))
+
+	for _, sess := range sessions {
+		sessionsProto = append(sessionsProto, &userProto.SessionInfo{
+			SessionId: sess.SessionID,
+			IpAddress: sess.IPAddress,
+			UserAgent: sess.UserAgent,
+			CreatedAt: timestamppb.New(sess.CreatedAt),
+			ExpiresAt: timestamppb.New(sess.ExpiresAt),
+			Current:   sess.SessionID == currentSessionID,
+		})
+	}
 
-		image, err := utils.ReadImage(c, "file")
-		if err != nil {
-			utils.LogResponseError(c, h.logger, err)
-			return c.JSON(httpErrors.ErrorResponse(err))
-		}
+	return sessionsProto
+}
 
-		file, err := image.Open()
-		if err != nil {
-			utils.LogResponseError(c, h.logger, err)
-			return c.JSON(httpErrors.ErrorResponse(err))
-		}
-		defer file.Close()
+// Create session for logged in user and optionally issue access and refresh tokens
+func (u *usersService) createLoginResponse(ctx context.Context, user *models.User, issueTokens bool) (*userProto.LoginResponse, error) {
+	session, err := u.sessUC.CreateSession(ctx, &models.Session{
+		UserID:    user.UserID,
+		IPAddress: utils.GetClientIP(ctx, u.cfg.Server.TrustedProxies),
+		UserAgent: utils.GetUserAgent(ctx),
+	}, u.cfg.Session.Expire)
+	if err != nil {
+		u.logger.Errorf("sessUC.CreateSession: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "sessUC.CreateSession: %v", err)
+	}
 
-		binaryImage := bytes.NewBuffer(nil)
-		if _, err = io.Copy(binaryImage, file); err != nil {
-			utils.LogResponseError(c, h.logger, err)
-			return c.JSON(httpErrors.ErrorResponse(err))
-		}
+	if err = u.userUC.CompleteLogin(ctx, user); err != nil {
+		u.logger.Errorf("userUC.CompleteLogin: %v", err)
+	}
 
-		contentType, err := utils.CheckImageFileContentType(binaryImage.Bytes())
-		if err != nil {
-			utils.LogResponseError(c, h.logger, err)
-			return c.JSON(httpErrors.ErrorResponse(err))
+	res := &userProto.LoginResponse{User: u.userModelToProto(user), SessionId: session}
+	if !issueTokens {
+		return res, nil
+	}
+
+	accessToken, expiresAt, err := utils.GenerateJWTToken(user, session, u.cfg)
+	if err != nil {
+		u.logger.Errorf("utils.GenerateJWTToken: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "utils.GenerateJWTToken: %v", err)
+	}
+
+	refreshToken, err := u.sessUC.CreateRefreshToken(ctx, &models.Session{
+		SessionID: session,
+		UserID:    user.UserID,
+	}, u.cfg.Jwt.RefreshTokenExpire)
+	if err != nil {
+		u.logger.Errorf("sessUC.CreateRefreshToken: %v", err)
+		return nil, status.Errorf(grpc_errors.ParseGRPCErrStatusCode(err), "sessUC.CreateRefreshToken: %v", err)
+	}
+
+	res.AccessToken = accessToken
+	res.RefreshToken = refreshToken
+	res.AccessTokenExpiresAt = timestamppb.New(expiresAt)
+
+	return res, nil
+}
+
+func (u *usersService) userFilterProtoToModel(filter *userProto.UserFilter) (*models.UserFilter, error) {
+	if filter == nil {
+		return nil, nil
+	}
+
+	userFilter := &models.UserFilter{
+		Roles:          filter.GetRoles(),
+		Genders:        filter.GetGenders(),
+		Cities:         filter.GetCities(),
+		Countries:      filter.GetCountries(),
+		IncludeDeleted: filter.GetIncludeDeleted(),
+	}
+
+	ranges := []struct {
+		name  string
+		proto *userProto.TimeRange
+		model *models.TimeRange
+	}{
+		{name: "created_at", proto: filter.GetCreatedAt(), model: &userFilter.CreatedAt},
+		{name: "updated_at", proto: filter.GetUpdatedAt(), model: &userFilter.UpdatedAt},
+		{name: "login_date", proto: filter.GetLoginDate(), model: &userFilter.LoginDate},
+		{name: "birthday", proto: filter.GetBirthday(), model: &userFilter.Birthday},
+	}
+	for _, r := range ranges {
+		if r.proto.GetFrom() != nil {
+			from := r.proto.GetFrom().AsTime()
+			r.model.From = &from
+		}
+		if r.proto.GetTo() != nil {
+			to := r.proto.GetTo().AsTime()
+			r.model.To = &to
 		}
+		if r.model.From != nil && r.model.To != nil && !r.model.From.Before(*r.model.To) {
+			return nil, fmt.Errorf("%s range from must be before to", r.name)
+		}
+	}
 
-		reader := bytes.NewReader(binaryImage.Bytes())
+	return userFilter, nil
+}
 
-		updatedUser, err := h.authUC.UploadAvatar(ctx, uID, models.UploadInput{
-			File:        reader,
-			Name:        image.Filename,
-			Size:        image.Size,
-			ContentType: contentType,
+func (u *usersService) auditEventModelToProto(event *models.AuditEvent) *userProto.AuditEvent {
+	fields := make([]string, 0, len(event.Changes))
+	for field := range event.Changes {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	changes := make([]*userProto.FieldChange, 0, len(fields))
+	for _, field := range fields {
+		changes = append(changes, &userProto.FieldChange{
+			Field:  field,
+			Before: event.Changes[field].Before,
+			After:  event.Changes[field].After,
 		})
-		if err != nil {
-			utils.LogResponseError(c, h.logger, err)
-			return c.JSON(httpErrors.ErrorResponse(err))
+	}
+
+	return &userProto.AuditEvent{
+		EventId:   event.EventID.String(),
+		ActorId:   event.ActorID.String(),
+		TargetId:  event.TargetID.String(),
+		Action:    event.Action,
+		Changes:   changes,
+		IpAddress: event.IPAddress,
+		CreatedAt: timestamppb.New(event.CreatedAt),
+	}
+}
+
+// Fields of user set to non zero values, used when update mask is empty
+func userUpdateFields(user *userProto.User) []string {
+	values := map[string]bool{
+		"first_name":   user.GetFirstName() != "",
+		"last_name":    user.GetLastName() != "",
+		"email":        user.GetEmail() != "",
+		"about":        user.GetAbout() != "",
+		"phone_number": user.GetPhoneNumber() != "",
+		"address":      user.GetAddress() != "",
+		"city":         user.GetCity() != "",
+		"country":      user.GetCountry() != "",
+		"gender":       user.GetGender() != "",
+		"postcode":     user.GetPostcode() != 0,
+		"birthday":     user.GetBirthday() != nil,
+	}
+
+	fields := make([]string, 0, len(values))
+	for field, set := range values {
+		if set {
+			fields = append(fields, field)
 		}
+	}
+	sort.Strings(fields)
+
+	return fields
+}
 
-		return c.JSON(http.StatusOK, updatedUser)
+// Empty string is an unset optional field
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
 	}
+	return &s
 }
-*/
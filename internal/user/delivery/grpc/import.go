@@ -0,0 +1,58 @@
+package grpc
+
+import (
+	"github.com/google/uuid"
+
+	"github.com/JamesHsu333/go-grpc/internal/models"
+	userProto "github.com/JamesHsu333/go-grpc/proto/user"
+)
+
+// Rows accepted by single import
+const maxImportRows = 100000
+
+func importRowProtoToModel(row *userProto.ImportUserRow) *models.ImportRow {
+	role := row.GetRole()
+	gender := row.GetGender()
+	user := &models.User{
+		FirstName:   row.GetFirstName(),
+		LastName:    row.GetLastName(),
+		Email:       row.GetEmail(),
+		Password:    row.GetPassword(),
+		Role:        &role,
+		About:       optionalString(row.GetAbout()),
+		PhoneNumber: optionalString(row.GetPhoneNumber()),
+		Address:     optionalString(row.GetAddress()),
+		City:        optionalString(row.GetCity()),
+		Country:     optionalString(row.GetCountry()),
+		Gender:      &gender,
+	}
+	if row.GetPostcode() != 0 {
+		postcode := int(row.GetPostcode())
+		user.Postcode = &postcode
+	}
+	if row.GetBirthday() != nil {
+		birthday := row.GetBirthday().AsTime()
+		user.Birthday = &birthday
+	}
+
+	return &models.ImportRow{Line: row.GetLine(), User: user}
+}
+
+func importResultsModelToProto(results []*models.ImportResult, dryRun bool) *userProto.ImportUsersResponse {
+	res := &userProto.ImportUsersResponse{DryRun: dryRun, Results: make([]*userProto.ImportUserResult, 0, len(results))}
+	for _, result := range results {
+		resultProto := &userProto.ImportUserResult{Line: result.Line, Email: result.Email, Success: result.Err == nil}
+		if result.Err != nil {
+			resultProto.Error = result.Err.Error()
+			res.Failed++
+		} else {
+			res.Imported++
+		}
+		if result.UserID != uuid.Nil {
+			resultProto.UserId = result.UserID.String()
+		}
+		res.Results = append(res.Results, resultProto)
+	}
+
+	return res
+}
@@ -0,0 +1,12 @@
+package user
+
+import (
+	"context"
+
+	"github.com/JamesHsu333/go-grpc/internal/models"
+)
+
+// Domain event publisher interface, delivery is at least once so consumers deduplicate by event id
+type EventPublisher interface {
+	Publish(ctx context.Context, event *models.OutboxEvent) error
+}
@@ -0,0 +1,12 @@
+package user
+
+import (
+	"context"
+
+	"github.com/JamesHsu333/go-grpc/internal/models"
+)
+
+// Mail sender interface
+type MailSender interface {
+	Send(ctx context.Context, mail *models.Mail) error
+}
@@ -0,0 +1,21 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/JamesHsu333/go-grpc/internal/models"
+)
+
+// Outbox repository interface
+type OutboxRepository interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+	Create(ctx context.Context, event *models.OutboxEvent) error
+	LockPending(ctx context.Context, limit int) ([]*models.OutboxEvent, error)
+	MarkPublished(ctx context.Context, eventID uuid.UUID) error
+	MarkFailed(ctx context.Context, eventID uuid.UUID, lastError string, nextAttemptAt time.Time) error
+	MarkDeadLetter(ctx context.Context, eventID uuid.UUID, attempts int, lastError string) error
+	DeletePublishedBefore(ctx context.Context, before time.Time) (int64, error)
+}
@@ -2,6 +2,7 @@ package user
 
 import (
 	"context"
+	"time"
 
 	"github.com/JamesHsu333/go-grpc/internal/models"
 	"github.com/JamesHsu333/go-grpc/pkg/utils"
@@ -10,12 +11,28 @@ import (
 
 // User repository interface
 type UserRepository interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
 	Register(ctx context.Context, user *models.User) (*models.User, error)
-	Update(ctx context.Context, user *models.User) (*models.User, error)
+	Update(ctx context.Context, user *models.User, fields []string) (*models.User, error)
 	Delete(ctx context.Context, userID uuid.UUID) error
+	Restore(ctx context.Context, userID uuid.UUID) (*models.User, error)
+	PurgeDeleted(ctx context.Context, before time.Time) ([]string, error)
 	GetByID(ctx context.Context, userID uuid.UUID) (*models.User, error)
+	FindExistingEmails(ctx context.Context, emails []string) ([]string, error)
+	GetByIDs(ctx context.Context, userIDs []uuid.UUID) ([]*models.User, error)
+	GetByIDForUpdate(ctx context.Context, userID uuid.UUID) (*models.User, error)
 	FindByName(ctx context.Context, name string, p *utils.PaginationQuery) (*models.UsersList, error)
 	FindByEmail(ctx context.Context, email string) (*models.User, error)
-	GetUsers(ctx context.Context, p *utils.PaginationQuery) (*models.UsersList, error)
+	SearchUsers(ctx context.Context, query string, p *utils.PaginationQuery) (*models.UserSearchList, error)
+	GetUsers(ctx context.Context, filter *models.UserFilter, p *utils.PaginationQuery) (*models.UsersList, error)
+	ExportUsers(ctx context.Context, filter *models.UserFilter, fn func(user *models.User) error) error
 	UpdateRole(ctx context.Context, user *models.User) (*models.User, error)
+	UpdateAvatar(ctx context.Context, userID uuid.UUID, avatar *string) (*models.User, error)
+	UpdatePassword(ctx context.Context, userID uuid.UUID, password string) error
+	VerifyEmail(ctx context.Context, userID uuid.UUID, email string) (*models.User, error)
+	CreateTOTP(ctx context.Context, userID uuid.UUID, secret string, recoveryCodeHashes []string) error
+	GetTOTP(ctx context.Context, userID uuid.UUID) (*models.TOTP, error)
+	EnableTOTP(ctx context.Context, userID uuid.UUID) error
+	DeleteTOTP(ctx context.Context, userID uuid.UUID) error
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) error
 }
@@ -3,13 +3,29 @@ package user
 
 import (
 	"context"
+	"time"
 
 	"github.com/JamesHsu333/go-grpc/internal/models"
+	"github.com/google/uuid"
 )
 
 // Auth Redis repository interface
 type RedisRepository interface {
-	GetByIDCtx(ctx context.Context, key string) (*models.User, error)
-	SetUserCtx(ctx context.Context, key string, seconds int, user *models.User) error
+	GetUserEntryCtx(ctx context.Context, key string) (*models.UserCacheEntry, error)
+	GetUserEntriesCtx(ctx context.Context, keys []string) ([]*models.UserCacheEntry, error)
+	SetUserEntryCtx(ctx context.Context, key string, entry *models.UserCacheEntry) error
+	SetUserEntriesCtx(ctx context.Context, entries map[string]*models.UserCacheEntry) error
 	DeleteUserCtx(ctx context.Context, key string) error
+	SetTokenCtx(ctx context.Context, key string, seconds int, userID uuid.UUID) error
+	PopTokenCtx(ctx context.Context, key string) (uuid.UUID, error)
+	SetOwnedTokenCtx(ctx context.Context, key string, ownerKey string, seconds int, userID uuid.UUID) error
+	DeleteOwnedTokenCtx(ctx context.Context, ownerKey string) error
+	SetEmailTokenCtx(ctx context.Context, key string, ownerKey string, seconds int, token *models.EmailToken) error
+	PopEmailTokenCtx(ctx context.Context, key string) (*models.EmailToken, error)
+	IncrCounterCtx(ctx context.Context, key string, seconds int) (int64, error)
+	GetCounterCtx(ctx context.Context, key string) (int64, error)
+	SetGreaterCtx(ctx context.Context, key string, value int64, seconds int) (bool, error)
+	LockCtx(ctx context.Context, key string, seconds int) error
+	GetLockTTLCtx(ctx context.Context, key string) (time.Duration, error)
+	DeleteKeysCtx(ctx context.Context, keys ...string) error
 }
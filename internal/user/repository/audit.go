@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/pgtype"
+	"github.com/jmoiron/sqlx"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+
+	"github.com/JamesHsu333/go-grpc/internal/models"
+	"github.com/JamesHsu333/go-grpc/internal/user"
+	"github.com/JamesHsu333/go-grpc/pkg/database/postgres"
+	"github.com/JamesHsu333/go-grpc/pkg/utils"
+)
+
+// Audit Repository
+type auditRepo struct {
+	db *sqlx.DB
+}
+
+// Audit Repository constructor
+func NewAuditRepository(db *sqlx.DB) user.AuditRepository {
+	return &auditRepo{db: db}
+}
+
+// Create audit event, takes part in transaction of context if any
+func (a *auditRepo) Create(ctx context.Context, event *models.AuditEvent) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "auditRepo.Create")
+	defer span.Finish()
+
+	if _, err := postgres.Conn(ctx, a.db).ExecContext(ctx, createAuditEventQuery, event.ActorID, event.TargetID,
+		event.Action, event.Changes, event.IPAddress,
+	); err != nil {
+		return errors.Wrap(err, "auditRepo.Create.ExecContext")
+	}
+
+	return nil
+}
+
+// List audit events matching filter, newest first
+func (a *auditRepo) List(ctx context.Context, filter *models.AuditFilter, pq *utils.PaginationQuery) (*models.AuditEventsList, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "auditRepo.List")
+	defer span.Finish()
+
+	q := newListQuery()
+	if filter.ActorID != nil {
+		q.addCondition("actor_id = %s", *filter.ActorID)
+	}
+	if filter.TargetID != nil {
+		q.addCondition("target_id = %s", *filter.TargetID)
+	}
+	if len(filter.Actions) > 0 {
+		actions := &pgtype.TextArray{}
+		_ = actions.Set(filter.Actions)
+		q.addCondition("action = ANY(%s)", actions)
+	}
+	q.addRangeCondition("created_at", filter.CreatedAt, timestampValue)
+
+	query := listAuditEventsQuery + q.whereSQL() +
+		" ORDER BY created_at DESC, event_id DESC OFFSET " + q.bind(pq.GetOffset()) + " LIMIT " + q.bind(pq.GetLimit()+1)
+
+	var events = make([]*models.AuditEvent, 0, pq.GetSize())
+	if err := a.db.SelectContext(ctx, &events, query, q.args...); err != nil {
+		return nil, errors.Wrap(err, "auditRepo.List.SelectContext")
+	}
+
+	hasMore := len(events) > pq.GetLimit()
+	if hasMore {
+		events = events[:pq.GetLimit()]
+	}
+
+	return &models.AuditEventsList{
+		Page:    pq.GetPage(),
+		Size:    pq.GetSize(),
+		HasMore: hasMore,
+		Events:  events,
+	}, nil
+}
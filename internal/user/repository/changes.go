@@ -0,0 +1,217 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/stdlib"
+	"github.com/jmoiron/sqlx"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+
+	"github.com/JamesHsu333/go-grpc/config"
+	"github.com/JamesHsu333/go-grpc/internal/models"
+	"github.com/JamesHsu333/go-grpc/internal/user"
+	"github.com/JamesHsu333/go-grpc/pkg/grpc_errors"
+	"github.com/JamesHsu333/go-grpc/pkg/logger"
+)
+
+const (
+	// Channel notified by users table trigger
+	userChangesChannel = "user_changes"
+	// Delay before listening again after listener connection failed
+	listenRetryDelay = 5 * time.Second
+)
+
+// Resume token, position of the last delivered change in commit order
+type changeToken struct {
+	TxID      int64 `json:"t"`
+	ChangeID  int64 `json:"c"`
+	ChangedAt int64 `json:"at"`
+}
+
+// User changes repository, one listener connection per process wakes all subscribers
+type userChangesRepo struct {
+	db          *sqlx.DB
+	cfg         *config.Config
+	logger      logger.Logger
+	mu          sync.Mutex
+	subscribers map[chan struct{}]struct{}
+}
+
+// User changes repository constructor
+func NewUserChangesRepository(db *sqlx.DB, cfg *config.Config, logger logger.Logger) user.ChangesRepository {
+	return &userChangesRepo{db: db, cfg: cfg, logger: logger, subscribers: make(map[chan struct{}]struct{})}
+}
+
+// Listen for change notifications until context is done, listener reconnects after connection errors
+func (r *userChangesRepo) Listen(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := r.listen(ctx); err != nil && ctx.Err() == nil {
+			r.logger.Errorf("userChangesRepo.Listen: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(listenRetryDelay):
+			}
+		}
+	}
+}
+
+// Wake subscribers on every notification, and once listening started as changes may have been missed meanwhile
+func (r *userChangesRepo) listen(ctx context.Context) error {
+	conn, err := stdlib.AcquireConn(r.db.DB)
+	if err != nil {
+		return errors.Wrap(err, "stdlib.AcquireConn")
+	}
+	defer func() {
+		if conn.IsAlive() {
+			_ = conn.Unlisten(userChangesChannel)
+		}
+		_ = stdlib.ReleaseConn(r.db.DB, conn)
+	}()
+
+	if err = conn.Listen(userChangesChannel); err != nil {
+		return errors.Wrap(err, "Conn.Listen")
+	}
+	r.broadcast()
+
+	for {
+		if _, err = conn.WaitForNotification(ctx); err != nil {
+			return errors.Wrap(err, "Conn.WaitForNotification")
+		}
+		r.broadcast()
+	}
+}
+
+// Signal subscribers without blocking, pending signal of a busy subscriber covers new changes too
+func (r *userChangesRepo) broadcast() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for subscriber := range r.subscribers {
+		select {
+		case subscriber <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Subscribe to change signals, returned func unsubscribes
+func (r *userChangesRepo) Subscribe() (<-chan struct{}, func()) {
+	subscriber := make(chan struct{}, 1)
+
+	r.mu.Lock()
+	r.subscribers[subscriber] = struct{}{}
+	r.mu.Unlock()
+
+	return subscriber, func() {
+		r.mu.Lock()
+		delete(r.subscribers, subscriber)
+		r.mu.Unlock()
+	}
+}
+
+// Token positioned after all changes visible now
+func (r *userChangesRepo) CurrentToken(ctx context.Context) (string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userChangesRepo.CurrentToken")
+	defer span.Finish()
+
+	var horizon int64
+	if err := r.db.QueryRowxContext(ctx, userChangesHorizonQuery).Scan(&horizon); err != nil {
+		return "", errors.Wrap(err, "userChangesRepo.CurrentToken.QueryRowxContext")
+	}
+
+	return encodeChangeToken(&changeToken{TxID: horizon - 1, ChangeID: math.MaxInt64, ChangedAt: time.Now().Unix()}), nil
+}
+
+// List changes after resume token in commit order, only changes of finished transactions are listed
+// so a change committed later never lands before an already delivered one. Changes of transactions
+// started after the oldest running one are held back, a long transaction stalls listing until it ends
+func (r *userChangesRepo) ListChanges(ctx context.Context, resumeToken string, types []string, limit int) ([]*models.UserChange, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userChangesRepo.ListChanges")
+	defer span.Finish()
+
+	token, err := decodeChangeToken(resumeToken)
+	if err != nil {
+		return nil, err
+	}
+
+	q := newListQuery()
+	q.where = append(q.where, "txid < txid_snapshot_xmin(txid_current_snapshot())")
+	q.addCondition("(txid, change_id) > (%s, %s)", token.TxID, token.ChangeID)
+	q.addInCondition("change_type", types)
+	query := fmt.Sprintf("%s%s ORDER BY txid, change_id LIMIT %d", listUserChangesQuery, q.whereSQL(), limit)
+
+	changes := make([]*models.UserChange, 0, limit)
+	if err = r.db.SelectContext(ctx, &changes, query, q.args...); err != nil {
+		return nil, errors.Wrap(err, "userChangesRepo.ListChanges.SelectContext")
+	}
+	for _, change := range changes {
+		change.ResumeToken = encodeChangeToken(&changeToken{TxID: change.TxID, ChangeID: change.ChangeID, ChangedAt: change.ChangedAt.Unix()})
+	}
+
+	return changes, nil
+}
+
+// Delete changes recorded before given time, tokens older than that expire
+func (r *userChangesRepo) DeleteChangesBefore(ctx context.Context, before time.Time) (int64, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userChangesRepo.DeleteChangesBefore")
+	defer span.Finish()
+
+	result, err := r.db.ExecContext(ctx, deleteUserChangesQuery, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "userChangesRepo.DeleteChangesBefore.ExecContext")
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "userChangesRepo.DeleteChangesBefore.RowsAffected")
+	}
+
+	return deleted, nil
+}
+
+func encodeChangeToken(token *changeToken) string {
+	tokenBytes, err := json.Marshal(token)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(tokenBytes)
+}
+
+// Check resume token of a reconnecting client, changes after it may have been deleted when it is older than retention
+func (r *userChangesRepo) CheckToken(resumeToken string) error {
+	token, err := decodeChangeToken(resumeToken)
+	if err != nil {
+		return err
+	}
+
+	retention := time.Duration(r.cfg.Watch.Retention) * time.Second
+	if retention > 0 && time.Since(time.Unix(token.ChangedAt, 0)) > retention {
+		return grpc_errors.ErrResumeExpired
+	}
+
+	return nil
+}
+
+func decodeChangeToken(resumeToken string) (*changeToken, error) {
+	tokenBytes, err := base64.RawURLEncoding.DecodeString(resumeToken)
+	if err != nil {
+		return nil, errors.Wrap(grpc_errors.ErrInvalidResume, err.Error())
+	}
+
+	token := &changeToken{}
+	if err = json.Unmarshal(tokenBytes, token); err != nil {
+		return nil, errors.Wrap(grpc_errors.ErrInvalidResume, err.Error())
+	}
+	if token.ChangedAt <= 0 {
+		return nil, grpc_errors.ErrInvalidResume
+	}
+
+	return token, nil
+}
@@ -6,10 +6,13 @@ import (
 	"io"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 
 	"github.com/JamesHsu333/go-grpc/config"
 	"github.com/JamesHsu333/go-grpc/internal/models"
 	"github.com/JamesHsu333/go-grpc/internal/user"
+	"github.com/JamesHsu333/go-grpc/pkg/grpc_errors"
 	"github.com/google/uuid"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
@@ -23,13 +26,14 @@ func NewuserFileRepository(cfg *config.Config) user.FileRepository {
 	return &userFileRepository{cfg: cfg}
 }
 
+// Store file under configured file path, returns generated object name
 func (f *userFileRepository) PutObject(ctx context.Context, input models.UploadInput) (*string, error) {
 	span, _ := opentracing.StartSpanFromContext(ctx, "userFileRepository.PutObject")
 	defer span.Finish()
 
-	filepath := path.Join(f.cfg.File.FilePath, f.generateFileName(input.Name))
+	objectName := f.generateFileName(input.Name)
 
-	dst, err := os.Create(filepath)
+	dst, err := os.Create(filepath.Join(f.cfg.File.FilePath, objectName))
 	if err != nil {
 		return nil, errors.Wrap(err, "userFileRepository.FileUpload.PutObject.os.Create")
 	}
@@ -38,22 +42,43 @@ func (f *userFileRepository) PutObject(ctx context.Context, input models.UploadI
 	if _, err = io.Copy(dst, input.File); err != nil {
 		return nil, errors.Wrap(err, "userFileRepository.FileUpload.PutObject.io.Copy")
 	}
-	return &filepath, nil
+	return &objectName, nil
 }
 
-func (f *userFileRepository) RemoveObject(ctx context.Context, filepath string) error {
-	span, _ := opentracing.StartSpanFromContext(ctx, "userFileRepository.PutObject")
+// Remove object by name, only files directly under configured file path can be removed
+func (f *userFileRepository) RemoveObject(ctx context.Context, objectName string) error {
+	span, _ := opentracing.StartSpanFromContext(ctx, "userFileRepository.RemoveObject")
 	defer span.Finish()
 
-	err := os.Remove(filepath)
+	objectPath, err := f.objectPath(objectName)
 	if err != nil {
+		return errors.Wrap(err, "userFileRepository.FileUpload.RemoveObject.objectPath")
+	}
+
+	if err = os.Remove(objectPath); err != nil {
 		return errors.Wrap(err, "userFileRepository.FileUpload.RemoveObject.os.Remove")
 	}
 
 	return nil
 }
 
+// Resolve object name under file path, names stored with file path prefix before only names were stored
+// are accepted as well
+func (f *userFileRepository) objectPath(objectName string) (string, error) {
+	root := filepath.Clean(f.cfg.File.FilePath)
+	objectPath := filepath.Join(root, objectName)
+	if strings.HasPrefix(objectName, root+string(filepath.Separator)) {
+		objectPath = filepath.Clean(objectName)
+	}
+
+	rel, err := filepath.Rel(root, objectPath)
+	if err != nil || rel == "." || rel == ".." || rel != filepath.Base(rel) {
+		return "", errors.Wrapf(grpc_errors.ErrInvalidObject, "object %q", objectName)
+	}
+	return objectPath, nil
+}
+
 func (f *userFileRepository) generateFileName(fileName string) string {
 	uid := uuid.New().String()
-	return fmt.Sprintf("%s-%s", uid, fileName)
+	return fmt.Sprintf("%s-%s", uid, path.Base(fileName))
 }
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/JamesHsu333/go-grpc/config"
+	"github.com/JamesHsu333/go-grpc/pkg/grpc_errors"
+)
+
+func TestObjectPath(t *testing.T) {
+	f := &userFileRepository{cfg: &config.Config{File: config.File{FilePath: "assets/images"}}}
+
+	tests := []struct {
+		name       string
+		objectName string
+		want       string
+		wantErr    bool
+	}{
+		{name: "object name", objectName: "avatar.png", want: "assets/images/avatar.png"},
+		{name: "stored with file path", objectName: "assets/images/avatar.png", want: "assets/images/avatar.png"},
+		{name: "empty", objectName: "", wantErr: true},
+		{name: "file path itself", objectName: "assets/images", wantErr: true},
+		{name: "parent directory", objectName: "../config.yml", wantErr: true},
+		{name: "escapes file path", objectName: "assets/images/../../go.mod", wantErr: true},
+		{name: "absolute path", objectName: "/etc/passwd", wantErr: true},
+		{name: "nested directory", objectName: "nested/avatar.png", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := f.objectPath(tt.objectName)
+			if tt.wantErr {
+				if !errors.Is(err, grpc_errors.ErrInvalidObject) {
+					t.Fatalf("objectPath() error = %v, want %v", err, grpc_errors.ErrInvalidObject)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("objectPath() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("objectPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/pgtype"
+	"github.com/pkg/errors"
+
+	"github.com/JamesHsu333/go-grpc/internal/models"
+	"github.com/JamesHsu333/go-grpc/pkg/grpc_errors"
+)
+
+// Column users can be sorted by, expression must never be NULL so keyset comparison works
+type sortColumn struct {
+	name  string
+	expr  string
+	cast  string
+	value func(user *models.User) string
+}
+
+// Sort column with direction
+type sortKey struct {
+	column sortColumn
+	desc   bool
+}
+
+var (
+	firstNameColumn = sortColumn{name: "first_name", expr: "first_name", cast: "text", value: func(user *models.User) string {
+		return user.FirstName
+	}}
+	lastNameColumn = sortColumn{name: "last_name", expr: "last_name", cast: "text", value: func(user *models.User) string {
+		return user.LastName
+	}}
+	emailColumn = sortColumn{name: "email", expr: "email", cast: "text", value: func(user *models.User) string {
+		return user.Email
+	}}
+	roleColumn = sortColumn{name: "role", expr: "role", cast: "text", value: func(user *models.User) string {
+		return stringValue(user.Role)
+	}}
+	cityColumn = sortColumn{name: "city", expr: "COALESCE(city, '')", cast: "text", value: func(user *models.User) string {
+		return stringValue(user.City)
+	}}
+	createdAtColumn = sortColumn{name: "created_at", expr: "created_at", cast: "timestamptz", value: func(user *models.User) string {
+		return user.CreatedAt.Format(time.RFC3339Nano)
+	}}
+	updatedAtColumn = sortColumn{name: "updated_at", expr: "COALESCE(updated_at, created_at)", cast: "timestamptz", value: func(user *models.User) string {
+		if user.UpdatedAt.IsZero() {
+			return user.CreatedAt.Format(time.RFC3339Nano)
+		}
+		return user.UpdatedAt.Format(time.RFC3339Nano)
+	}}
+	loginDateColumn = sortColumn{name: "login_date", expr: "login_date", cast: "timestamptz", value: func(user *models.User) string {
+		return user.LoginDate.Format(time.RFC3339Nano)
+	}}
+	birthdayColumn = sortColumn{name: "birthday", expr: "COALESCE(birthday, '-infinity'::date)", cast: "date", value: func(user *models.User) string {
+		if user.Birthday == nil {
+			return "-infinity"
+		}
+		return user.Birthday.Format("2006-01-02")
+	}}
+)
+
+// Columns users can be sorted by, keyed by api field name
+var sortColumns = map[string]sortColumn{
+	firstNameColumn.name: firstNameColumn,
+	lastNameColumn.name:  lastNameColumn,
+	emailColumn.name:     emailColumn,
+	roleColumn.name:      roleColumn,
+	cityColumn.name:      cityColumn,
+	createdAtColumn.name: createdAtColumn,
+	updatedAtColumn.name: updatedAtColumn,
+	loginDateColumn.name: loginDateColumn,
+	birthdayColumn.name:  birthdayColumn,
+}
+
+// Parse sort specification, e.g. "created_at desc, last_name asc", empty specification returns defaults
+func parseSort(spec string, defaults ...sortKey) ([]sortKey, error) {
+	if strings.TrimSpace(spec) == "" {
+		return defaults, nil
+	}
+
+	parts := strings.Split(spec, ",")
+	keys := make([]sortKey, 0, len(parts))
+	seen := make(map[string]bool, len(parts))
+	for _, part := range parts {
+		fields := strings.Fields(part)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, errors.Wrapf(grpc_errors.ErrInvalidSort, "%q", strings.TrimSpace(part))
+		}
+
+		name := strings.ToLower(fields[0])
+		column, ok := sortColumns[name]
+		if !ok {
+			return nil, errors.Wrapf(grpc_errors.ErrInvalidSort, "unknown field %q", fields[0])
+		}
+		if seen[name] {
+			return nil, errors.Wrapf(grpc_errors.ErrInvalidSort, "duplicate field %q", fields[0])
+		}
+		seen[name] = true
+
+		key := sortKey{column: column}
+		if len(fields) == 2 {
+			switch strings.ToLower(fields[1]) {
+			case "asc":
+			case "desc":
+				key.desc = true
+			default:
+				return nil, errors.Wrapf(grpc_errors.ErrInvalidSort, "unknown direction %q", fields[1])
+			}
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// Keyset pagination cursor, holds sort key values of the boundary row of a page
+type pageCursor struct {
+	Sort   string    `json:"s"`
+	Values []string  `json:"v"`
+	UserID uuid.UUID `json:"id"`
+	Prev   bool      `json:"p,omitempty"`
+}
+
+// Listing query, conditions are joined with AND and args are bound positionally
+type listQuery struct {
+	where []string
+	args  []interface{}
+	sort  []sortKey
+}
+
+func newListQuery(sort ...sortKey) *listQuery {
+	return &listQuery{sort: sort}
+}
+
+// Bind argument and return its placeholder
+func (q *listQuery) bind(arg interface{}) string {
+	q.args = append(q.args, arg)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// Add condition, format verbs are replaced with placeholders of bound args
+func (q *listQuery) addCondition(format string, args ...interface{}) {
+	placeholders := make([]interface{}, 0, len(args))
+	for _, arg := range args {
+		placeholders = append(placeholders, q.bind(arg))
+	}
+	q.where = append(q.where, fmt.Sprintf(format, placeholders...))
+}
+
+// Add conditions of users filter, deleted users are excluded unless requested
+func (q *listQuery) addUserFilter(filter *models.UserFilter) {
+	if filter == nil || !filter.IncludeDeleted {
+		q.where = append(q.where, "deleted_at IS NULL")
+	}
+	if filter == nil {
+		return
+	}
+
+	q.addInCondition("role", filter.Roles)
+	q.addInCondition("gender", filter.Genders)
+	q.addInCondition("city", filter.Cities)
+	q.addInCondition("country", filter.Countries)
+	q.addRangeCondition("created_at", filter.CreatedAt, timestampValue)
+	q.addRangeCondition("updated_at", filter.UpdatedAt, timestampValue)
+	q.addRangeCondition("login_date", filter.LoginDate, timestampValue)
+	q.addRangeCondition("birthday", filter.Birthday, dateValue)
+}
+
+func (q *listQuery) addInCondition(column string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	array := &pgtype.TextArray{}
+	_ = array.Set(values)
+	q.addCondition(column+" = ANY(%s)", array)
+}
+
+func (q *listQuery) addRangeCondition(column string, r models.TimeRange, value func(t time.Time) interface{}) {
+	if r.From != nil {
+		q.addCondition(column+" >= %s", value(*r.From))
+	}
+	if r.To != nil {
+		q.addCondition(column+" < %s", value(*r.To))
+	}
+}
+
+func (q *listQuery) whereSQL() string {
+	if len(q.where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.where, " AND ")
+}
+
+// Count query of current conditions
+func (q *listQuery) countSQL() (string, []interface{}) {
+	return countUsersQuery + q.whereSQL(), q.args
+}
+
+// Select query of all rows matching current conditions, ordered by user id
+func (q *listQuery) exportSQL() (string, []interface{}) {
+	return listUsersQuery + q.whereSQL() + " ORDER BY user_id", q.args
+}
+
+// Select query of current conditions, keyset condition is added when cursor is set, otherwise offset is used
+func (q *listQuery) selectSQL(cursor *pageCursor, offset int, limit int) (string, []interface{}) {
+	prev := cursor != nil && cursor.Prev
+	if cursor != nil {
+		q.addKeysetCondition(cursor)
+	}
+
+	order := make([]string, 0, len(q.sort)+1)
+	for _, key := range q.sort {
+		order = append(order, key.column.expr+" "+direction(key.desc != prev))
+	}
+	order = append(order, "user_id "+direction(prev))
+
+	query := listUsersQuery + q.whereSQL() + " ORDER BY " + strings.Join(order, ", ")
+	if cursor == nil && offset > 0 {
+		query += " OFFSET " + q.bind(offset)
+	}
+	query += " LIMIT " + q.bind(limit)
+
+	return query, q.args
+}
+
+// Rows strictly after cursor in sort order, or before it for previous page
+func (q *listQuery) addKeysetCondition(cursor *pageCursor) {
+	values := make([]string, 0, len(cursor.Values)+1)
+	for i, key := range q.sort {
+		values = append(values, q.bind(cursor.Values[i])+"::"+key.column.cast)
+	}
+	values = append(values, q.bind(cursor.UserID)+"::uuid")
+
+	exprs := make([]string, 0, len(q.sort)+1)
+	descs := make([]bool, 0, len(q.sort)+1)
+	for _, key := range q.sort {
+		exprs = append(exprs, key.column.expr)
+		descs = append(descs, key.desc)
+	}
+	exprs = append(exprs, "user_id")
+	descs = append(descs, false)
+
+	or := make([]string, 0, len(exprs))
+	for i := range exprs {
+		and := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			and = append(and, exprs[j]+" = "+values[j])
+		}
+		op := ">"
+		if descs[i] != cursor.Prev {
+			op = "<"
+		}
+		and = append(and, exprs[i]+" "+op+" "+values[i])
+		or = append(or, "("+strings.Join(and, " AND ")+")")
+	}
+
+	q.where = append(q.where, "("+strings.Join(or, " OR ")+")")
+}
+
+// Sort specification used to bind cursors to the listing order they were created for
+func (q *listQuery) sortSpec() string {
+	spec := make([]string, 0, len(q.sort))
+	for _, key := range q.sort {
+		spec = append(spec, key.column.name+" "+direction(key.desc))
+	}
+	return strings.Join(spec, ",")
+}
+
+// Create cursor pointing at user
+func (q *listQuery) cursorFor(user *models.User, prev bool) string {
+	values := make([]string, 0, len(q.sort))
+	for _, key := range q.sort {
+		values = append(values, key.column.value(user))
+	}
+
+	cursorBytes, err := json.Marshal(&pageCursor{Sort: q.sortSpec(), Values: values, UserID: user.UserID, Prev: prev})
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(cursorBytes)
+}
+
+// Decode page token, it must have been created for the same sort
+func (q *listQuery) decodeCursor(token string) (*pageCursor, error) {
+	cursorBytes, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.Wrap(grpc_errors.ErrInvalidPageToken, err.Error())
+	}
+
+	cursor := &pageCursor{}
+	if err = json.Unmarshal(cursorBytes, cursor); err != nil {
+		return nil, errors.Wrap(grpc_errors.ErrInvalidPageToken, err.Error())
+	}
+	if cursor.Sort != q.sortSpec() || len(cursor.Values) != len(q.sort) {
+		return nil, grpc_errors.ErrInvalidPageToken
+	}
+
+	return cursor, nil
+}
+
+func timestampValue(t time.Time) interface{} {
+	return t
+}
+
+func dateValue(t time.Time) interface{} {
+	return t.Format("2006-01-02")
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func direction(desc bool) string {
+	if desc {
+		return "DESC"
+	}
+	return "ASC"
+}
@@ -0,0 +1,278 @@
+package repository
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/JamesHsu333/go-grpc/internal/models"
+	"github.com/JamesHsu333/go-grpc/pkg/grpc_errors"
+)
+
+func TestParseSort(t *testing.T) {
+	defaults := []sortKey{{column: createdAtColumn, desc: true}}
+
+	tests := []struct {
+		name    string
+		spec    string
+		want    []sortKey
+		wantErr bool
+	}{
+		{name: "empty returns defaults", spec: "", want: defaults},
+		{name: "blank returns defaults", spec: "  ", want: defaults},
+		{name: "default direction is asc", spec: "email", want: []sortKey{{column: emailColumn}}},
+		{
+			name: "multiple keys",
+			spec: "created_at desc, last_name asc",
+			want: []sortKey{{column: createdAtColumn, desc: true}, {column: lastNameColumn}},
+		},
+		{name: "case insensitive", spec: "City DESC", want: []sortKey{{column: cityColumn, desc: true}}},
+		{name: "unknown field", spec: "password", wantErr: true},
+		{name: "unknown direction", spec: "email up", wantErr: true},
+		{name: "duplicate field", spec: "email asc, EMAIL desc", wantErr: true},
+		{name: "too many tokens", spec: "email asc nulls", wantErr: true},
+		{name: "empty part", spec: "email,,city", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSort(tt.spec, defaults...)
+			if tt.wantErr {
+				if !errors.Is(err, grpc_errors.ErrInvalidSort) {
+					t.Fatalf("parseSort() error = %v, want %v", err, grpc_errors.ErrInvalidSort)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSort() unexpected error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseSort() got %d keys, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i].column.name != tt.want[i].column.name || got[i].desc != tt.want[i].desc {
+					t.Errorf("parseSort() key %d = %s %v, want %s %v",
+						i, got[i].column.name, got[i].desc, tt.want[i].column.name, tt.want[i].desc)
+				}
+			}
+		})
+	}
+}
+
+func TestAddKeysetCondition(t *testing.T) {
+	userID := uuid.MustParse("6f1c1a52-1f5e-4a57-9bd1-7d3f0a0c6a11")
+
+	tests := []struct {
+		name     string
+		sort     []sortKey
+		cursor   *pageCursor
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{
+			name:     "user id only",
+			cursor:   &pageCursor{UserID: userID},
+			wantSQL:  "((user_id > $1::uuid))",
+			wantArgs: []interface{}{userID},
+		},
+		{
+			name:     "ascending",
+			sort:     []sortKey{{column: lastNameColumn}},
+			cursor:   &pageCursor{Values: []string{"Doe"}, UserID: userID},
+			wantSQL:  "((last_name > $1::text) OR (last_name = $1::text AND user_id > $2::uuid))",
+			wantArgs: []interface{}{"Doe", userID},
+		},
+		{
+			name:     "descending",
+			sort:     []sortKey{{column: lastNameColumn, desc: true}},
+			cursor:   &pageCursor{Values: []string{"Doe"}, UserID: userID},
+			wantSQL:  "((last_name < $1::text) OR (last_name = $1::text AND user_id > $2::uuid))",
+			wantArgs: []interface{}{"Doe", userID},
+		},
+		{
+			name:     "previous page reverses comparison",
+			sort:     []sortKey{{column: lastNameColumn, desc: true}},
+			cursor:   &pageCursor{Values: []string{"Doe"}, UserID: userID, Prev: true},
+			wantSQL:  "((last_name > $1::text) OR (last_name = $1::text AND user_id < $2::uuid))",
+			wantArgs: []interface{}{"Doe", userID},
+		},
+		{
+			name:   "nullable updated at",
+			sort:   []sortKey{{column: updatedAtColumn, desc: true}},
+			cursor: &pageCursor{Values: []string{"2021-01-02T03:04:05Z"}, UserID: userID},
+			wantSQL: "((COALESCE(updated_at, created_at) < $1::timestamptz) OR " +
+				"(COALESCE(updated_at, created_at) = $1::timestamptz AND user_id > $2::uuid))",
+			wantArgs: []interface{}{"2021-01-02T03:04:05Z", userID},
+		},
+		{
+			name:   "multiple keys with expressions",
+			sort:   []sortKey{{column: birthdayColumn, desc: true}, {column: cityColumn}},
+			cursor: &pageCursor{Values: []string{"-infinity", "Berlin"}, UserID: userID},
+			wantSQL: "((COALESCE(birthday, '-infinity'::date) < $1::date) OR " +
+				"(COALESCE(birthday, '-infinity'::date) = $1::date AND COALESCE(city, '') > $2::text) OR " +
+				"(COALESCE(birthday, '-infinity'::date) = $1::date AND COALESCE(city, '') = $2::text AND user_id > $3::uuid))",
+			wantArgs: []interface{}{"-infinity", "Berlin", userID},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newListQuery(tt.sort...)
+			q.addKeysetCondition(tt.cursor)
+
+			if len(q.where) != 1 || q.where[0] != tt.wantSQL {
+				t.Errorf("addKeysetCondition() where = %q, want %q", q.where, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(q.args, tt.wantArgs) {
+				t.Errorf("addKeysetCondition() args = %#v, want %#v", q.args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestSelectSQL(t *testing.T) {
+	userID := uuid.MustParse("6f1c1a52-1f5e-4a57-9bd1-7d3f0a0c6a11")
+	sort := []sortKey{{column: createdAtColumn, desc: true}}
+
+	tests := []struct {
+		name     string
+		where    []string
+		cursor   *pageCursor
+		offset   int
+		limit    int
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{
+			name:     "first page",
+			limit:    10,
+			wantSQL:  " ORDER BY created_at DESC, user_id ASC LIMIT $1",
+			wantArgs: []interface{}{10},
+		},
+		{
+			name:     "offset page with condition",
+			where:    []string{"deleted_at IS NULL"},
+			offset:   20,
+			limit:    10,
+			wantSQL:  " WHERE deleted_at IS NULL ORDER BY created_at DESC, user_id ASC OFFSET $1 LIMIT $2",
+			wantArgs: []interface{}{20, 10},
+		},
+		{
+			name:   "next page ignores offset",
+			cursor: &pageCursor{Values: []string{"2021-01-02T03:04:05Z"}, UserID: userID},
+			offset: 20,
+			limit:  10,
+			wantSQL: " WHERE ((created_at < $1::timestamptz) OR (created_at = $1::timestamptz AND user_id > $2::uuid))" +
+				" ORDER BY created_at DESC, user_id ASC LIMIT $3",
+			wantArgs: []interface{}{"2021-01-02T03:04:05Z", userID, 10},
+		},
+		{
+			name:   "previous page reverses order",
+			cursor: &pageCursor{Values: []string{"2021-01-02T03:04:05Z"}, UserID: userID, Prev: true},
+			limit:  10,
+			wantSQL: " WHERE ((created_at > $1::timestamptz) OR (created_at = $1::timestamptz AND user_id < $2::uuid))" +
+				" ORDER BY created_at ASC, user_id DESC LIMIT $3",
+			wantArgs: []interface{}{"2021-01-02T03:04:05Z", userID, 10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newListQuery(sort...)
+			q.where = append(q.where, tt.where...)
+
+			query, args := q.selectSQL(tt.cursor, tt.offset, tt.limit)
+			if !strings.HasPrefix(query, listUsersQuery) {
+				t.Fatalf("selectSQL() query = %q, want prefix %q", query, listUsersQuery)
+			}
+			if got := strings.TrimPrefix(query, listUsersQuery); got != tt.wantSQL {
+				t.Errorf("selectSQL() query = %q, want %q", got, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("selectSQL() args = %#v, want %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestDecodeCursor(t *testing.T) {
+	user := &models.User{
+		UserID:    uuid.MustParse("6f1c1a52-1f5e-4a57-9bd1-7d3f0a0c6a11"),
+		LastName:  "Doe",
+		CreatedAt: time.Date(2021, time.January, 2, 3, 4, 5, 0, time.UTC),
+	}
+	q := newListQuery(sortKey{column: createdAtColumn, desc: true}, sortKey{column: lastNameColumn})
+	otherSort := newListQuery(sortKey{column: createdAtColumn}, sortKey{column: lastNameColumn})
+
+	tests := []struct {
+		name    string
+		token   string
+		want    *pageCursor
+		wantErr bool
+	}{
+		{
+			name:  "next page",
+			token: q.cursorFor(user, false),
+			want: &pageCursor{
+				Sort:   "created_at DESC,last_name ASC",
+				Values: []string{"2021-01-02T03:04:05Z", "Doe"},
+				UserID: user.UserID,
+			},
+		},
+		{
+			name:  "previous page",
+			token: q.cursorFor(user, true),
+			want: &pageCursor{
+				Sort:   "created_at DESC,last_name ASC",
+				Values: []string{"2021-01-02T03:04:05Z", "Doe"},
+				UserID: user.UserID,
+				Prev:   true,
+			},
+		},
+		{name: "not base64", token: "not a token!", wantErr: true},
+		{name: "not json", token: base64.RawURLEncoding.EncodeToString([]byte("cursor")), wantErr: true},
+		{name: "other sort", token: otherSort.cursorFor(user, false), wantErr: true},
+		{
+			name:    "values do not match sort",
+			token:   base64.RawURLEncoding.EncodeToString([]byte(`{"s":"created_at DESC,last_name ASC","v":["x"]}`)),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := q.decodeCursor(tt.token)
+			if tt.wantErr {
+				if !errors.Is(err, grpc_errors.ErrInvalidPageToken) {
+					t.Fatalf("decodeCursor() error = %v, want %v", err, grpc_errors.ErrInvalidPageToken)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeCursor() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeCursor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdatedAtColumnValue(t *testing.T) {
+	createdAt := time.Date(2021, time.January, 2, 3, 4, 5, 0, time.UTC)
+
+	never := &models.User{CreatedAt: createdAt}
+	if got := updatedAtColumn.value(never); got != "2021-01-02T03:04:05Z" {
+		t.Errorf("value() of never updated user = %q, want created at", got)
+	}
+
+	updated := &models.User{CreatedAt: createdAt, UpdatedAt: createdAt.Add(time.Hour)}
+	if got := updatedAtColumn.value(updated); got != "2021-01-02T04:04:05Z" {
+		t.Errorf("value() of updated user = %q, want updated at", got)
+	}
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path"
+	"time"
+
+	"github.com/JamesHsu333/go-grpc/config"
+	"github.com/JamesHsu333/go-grpc/internal/models"
+	"github.com/JamesHsu333/go-grpc/internal/user"
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+)
+
+// Mail sender writing every mail as a file into outbox folder
+type userMailFileSender struct {
+	cfg *config.Config
+}
+
+// Mail file sender constructor
+func NewUserMailFileSender(cfg *config.Config) user.MailSender {
+	return &userMailFileSender{cfg: cfg}
+}
+
+// Write mail into outbox folder
+func (m *userMailFileSender) Send(ctx context.Context, mail *models.Mail) error {
+	span, _ := opentracing.StartSpanFromContext(ctx, "userMailFileSender.Send")
+	defer span.Finish()
+
+	if err := os.MkdirAll(m.cfg.Mail.OutboxPath, 0o755); err != nil {
+		return errors.Wrap(err, "userMailFileSender.Send.os.MkdirAll")
+	}
+
+	filepath := path.Join(m.cfg.Mail.OutboxPath, fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), uuid.New().String()))
+	if err := os.WriteFile(filepath, formatMail(m.cfg.Mail.From, mail), 0o600); err != nil {
+		return errors.Wrap(err, "userMailFileSender.Send.os.WriteFile")
+	}
+	return nil
+}
+
+// Mail sender delivering mails through smtp server
+type userMailSMTPSender struct {
+	cfg *config.Config
+}
+
+// Mail smtp sender constructor
+func NewUserMailSMTPSender(cfg *config.Config) user.MailSender {
+	return &userMailSMTPSender{cfg: cfg}
+}
+
+// Send mail through smtp server
+func (m *userMailSMTPSender) Send(ctx context.Context, mail *models.Mail) error {
+	span, _ := opentracing.StartSpanFromContext(ctx, "userMailSMTPSender.Send")
+	defer span.Finish()
+
+	var auth smtp.Auth
+	if m.cfg.Mail.SMTPUser != "" {
+		auth = smtp.PlainAuth("", m.cfg.Mail.SMTPUser, m.cfg.Mail.SMTPPassword, m.cfg.Mail.SMTPHost)
+	}
+
+	addr := net.JoinHostPort(m.cfg.Mail.SMTPHost, m.cfg.Mail.SMTPPort)
+	if err := smtp.SendMail(addr, auth, m.cfg.Mail.From, []string{mail.To}, formatMail(m.cfg.Mail.From, mail)); err != nil {
+		return errors.Wrap(err, "userMailSMTPSender.Send.smtp.SendMail")
+	}
+	return nil
+}
+
+func formatMail(from string, mail *models.Mail) []byte {
+	return []byte(fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n", from, mail.To, mail.Subject, mail.Body))
+}
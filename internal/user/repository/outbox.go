@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+
+	"github.com/JamesHsu333/go-grpc/internal/models"
+	"github.com/JamesHsu333/go-grpc/internal/user"
+	"github.com/JamesHsu333/go-grpc/pkg/database/postgres"
+)
+
+// Outbox Repository
+type outboxRepo struct {
+	db *sqlx.DB
+}
+
+// Outbox Repository constructor
+func NewOutboxRepository(db *sqlx.DB) user.OutboxRepository {
+	return &outboxRepo{db: db}
+}
+
+// Run fn within transaction, repository calls with returned context take part in it
+func (o *outboxRepo) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return postgres.WithinTransaction(ctx, o.db, fn)
+}
+
+// Create outbox event, takes part in transaction of context if any
+func (o *outboxRepo) Create(ctx context.Context, event *models.OutboxEvent) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "outboxRepo.Create")
+	defer span.Finish()
+
+	if _, err := postgres.Conn(ctx, o.db).ExecContext(ctx, createOutboxEventQuery, event.AggregateID, event.Type,
+		string(event.Payload),
+	); err != nil {
+		return errors.Wrap(err, "outboxRepo.Create.ExecContext")
+	}
+
+	return nil
+}
+
+// Lock due unpublished events oldest first, locked events are skipped by other relays until transaction ends.
+// Events of aggregate are due one by one in order, a failed event holds back later events of its aggregate
+func (o *outboxRepo) LockPending(ctx context.Context, limit int) ([]*models.OutboxEvent, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "outboxRepo.LockPending")
+	defer span.Finish()
+
+	events := make([]*models.OutboxEvent, 0, limit)
+	if err := postgres.Conn(ctx, o.db).SelectContext(ctx, &events, lockPendingOutboxEventsQuery, limit); err != nil {
+		return nil, errors.Wrap(err, "outboxRepo.LockPending.SelectContext")
+	}
+
+	return events, nil
+}
+
+// Mark event published
+func (o *outboxRepo) MarkPublished(ctx context.Context, eventID uuid.UUID) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "outboxRepo.MarkPublished")
+	defer span.Finish()
+
+	if _, err := postgres.Conn(ctx, o.db).ExecContext(ctx, markOutboxEventPublishedQuery, eventID); err != nil {
+		return errors.Wrap(err, "outboxRepo.MarkPublished.ExecContext")
+	}
+
+	return nil
+}
+
+// Record failed publish attempt, event is retried at nextAttemptAt
+func (o *outboxRepo) MarkFailed(ctx context.Context, eventID uuid.UUID, lastError string, nextAttemptAt time.Time) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "outboxRepo.MarkFailed")
+	defer span.Finish()
+
+	if _, err := postgres.Conn(ctx, o.db).ExecContext(ctx, markOutboxEventFailedQuery, lastError, nextAttemptAt, eventID); err != nil {
+		return errors.Wrap(err, "outboxRepo.MarkFailed.ExecContext")
+	}
+
+	return nil
+}
+
+// Mark event failed after its last attempt, it is not retried until failed_at is cleared
+func (o *outboxRepo) MarkDeadLetter(ctx context.Context, eventID uuid.UUID, attempts int, lastError string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "outboxRepo.MarkDeadLetter")
+	defer span.Finish()
+
+	if _, err := postgres.Conn(ctx, o.db).ExecContext(ctx, markOutboxEventDeadLetterQuery, attempts, lastError, eventID); err != nil {
+		return errors.Wrap(err, "outboxRepo.MarkDeadLetter.ExecContext")
+	}
+
+	return nil
+}
+
+// Delete events published before given time, returns number of deleted events
+func (o *outboxRepo) DeletePublishedBefore(ctx context.Context, before time.Time) (int64, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "outboxRepo.DeletePublishedBefore")
+	defer span.Finish()
+
+	result, err := postgres.Conn(ctx, o.db).ExecContext(ctx, deletePublishedOutboxEventsQuery, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "outboxRepo.DeletePublishedBefore.ExecContext")
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "outboxRepo.DeletePublishedBefore.RowsAffected")
+	}
+
+	return deleted, nil
+}
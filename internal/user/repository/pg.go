@@ -3,16 +3,31 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"time"
 
 	"github.com/JamesHsu333/go-grpc/internal/models"
 	"github.com/JamesHsu333/go-grpc/internal/user"
+	"github.com/JamesHsu333/go-grpc/pkg/database/postgres"
+	"github.com/JamesHsu333/go-grpc/pkg/grpc_errors"
 	"github.com/JamesHsu333/go-grpc/pkg/utils"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
 	"github.com/jmoiron/sqlx"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 )
 
+// Postgres unique_violation error code, raised when email is taken by a soft deleted user
+const uniqueViolationCode = "23505"
+
+// Rows fetched from export cursor at once
+const exportFetchSize = 500
+
+// Advisory lock held while purging, so replicas do not purge at the same time
+const purgeLockKey = 4358234651208
+
 // User Repository
 type userRepo struct {
 	db *sqlx.DB
@@ -23,44 +38,61 @@ func NewUserRepository(db *sqlx.DB) user.UserRepository {
 	return &userRepo{db: db}
 }
 
+// Run fn within transaction, repository calls with returned context take part in it
+func (u *userRepo) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return postgres.WithinTransaction(ctx, u.db, fn)
+}
+
+func (u *userRepo) conn(ctx context.Context) postgres.Executor {
+	return postgres.Conn(ctx, u.db)
+}
+
 // Create new user
 func (u *userRepo) Register(ctx context.Context, user *models.User) (*models.User, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.Register")
 	defer span.Finish()
 
 	createdUser := &models.User{}
-	if err := u.db.QueryRowxContext(ctx, createUserQuery, &user.FirstName, &user.LastName, &user.Email,
+	if err := u.conn(ctx).QueryRowxContext(ctx, createUserQuery, &user.FirstName, &user.LastName, &user.Email,
 		&user.Password, &user.Role, &user.About, &user.Avatar, &user.PhoneNumber, &user.Address, &user.City,
-		&user.Gender, &user.Postcode, utils.ParseTimeFormat(user.Birthday),
+		&user.Gender, &user.Postcode, utils.ParseTimeFormat(user.Birthday), &user.Country,
 	).StructScan(createdUser); err != nil {
+		if pgErr, ok := err.(pgx.PgError); ok && pgErr.Code == uniqueViolationCode {
+			return nil, errors.Wrap(grpc_errors.ErrEmailExists, "userRepo.Register.StructScan")
+		}
 		return nil, errors.Wrap(err, "userRepo.Register.StructScan")
 	}
 
 	return createdUser, nil
 }
 
-// Update existing user
-func (u *userRepo) Update(ctx context.Context, user *models.User) (*models.User, error) {
+// Update given fields of existing user, nil fields are cleared
+func (u *userRepo) Update(ctx context.Context, user *models.User, fields []string) (*models.User, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.Update")
 	defer span.Finish()
 
+	query, args, err := updateUserSQL(user, fields)
+	if err != nil {
+		return nil, errors.Wrap(err, "userRepo.Update.updateUserSQL")
+	}
+
 	updatedUser := &models.User{}
-	if err := u.db.GetContext(ctx, updatedUser, updateUserQuery, &user.FirstName, &user.LastName, &user.Email,
-		&user.About, &user.Avatar, &user.PhoneNumber, &user.Address, &user.City, &user.Gender,
-		&user.Postcode, utils.ParseTimeFormat(user.Birthday), &user.UserID,
-	); err != nil {
+	if err := u.conn(ctx).GetContext(ctx, updatedUser, query, args...); err != nil {
+		if pgErr, ok := err.(pgx.PgError); ok && pgErr.Code == uniqueViolationCode {
+			return nil, errors.Wrap(grpc_errors.ErrEmailExists, "userRepo.Update.GetContext")
+		}
 		return nil, errors.Wrap(err, "userRepo.Update.GetContext")
 	}
 
 	return updatedUser, nil
 }
 
-// Delete existing user
+// Soft delete existing user, deleted users are hidden from reads until restored or purged
 func (u *userRepo) Delete(ctx context.Context, userID uuid.UUID) error {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.Delete")
 	defer span.Finish()
 
-	result, err := u.db.ExecContext(ctx, deleteUserQuery, userID)
+	result, err := u.conn(ctx).ExecContext(ctx, deleteUserQuery, userID)
 	if err != nil {
 		return errors.WithMessage(err, "userRepo Delete ExecContext")
 	}
@@ -75,66 +107,152 @@ func (u *userRepo) Delete(ctx context.Context, userID uuid.UUID) error {
 	return nil
 }
 
+// Restore soft deleted user
+func (u *userRepo) Restore(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.Restore")
+	defer span.Finish()
+
+	restoredUser := &models.User{}
+	if err := u.conn(ctx).GetContext(ctx, restoredUser, restoreUserQuery, userID); err != nil {
+		return nil, errors.Wrap(err, "userRepo.Restore.GetContext")
+	}
+	return restoredUser, nil
+}
+
+// Hard delete users soft deleted before given time, returns avatars of purged users.
+// Nothing is purged while another purge holds the lock
+func (u *userRepo) PurgeDeleted(ctx context.Context, before time.Time) ([]string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.PurgeDeleted")
+	defer span.Finish()
+
+	var avatars []*string
+	if err := u.WithinTransaction(ctx, func(ctx context.Context) error {
+		var locked bool
+		if err := u.conn(ctx).GetContext(ctx, &locked, tryLockPurgeQuery, purgeLockKey); err != nil {
+			return errors.Wrap(err, "userRepo.PurgeDeleted.GetContext")
+		}
+		if !locked {
+			return nil
+		}
+		if err := u.conn(ctx).SelectContext(ctx, &avatars, purgeUsersQuery, before); err != nil {
+			return errors.Wrap(err, "userRepo.PurgeDeleted.SelectContext")
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	purged := make([]string, 0, len(avatars))
+	for _, avatar := range avatars {
+		purged = append(purged, stringValue(avatar))
+	}
+
+	return purged, nil
+}
+
 // Get user by id
 func (u *userRepo) GetByID(ctx context.Context, userID uuid.UUID) (*models.User, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.GetByID")
 	defer span.Finish()
 
 	user := &models.User{}
-	if err := u.db.QueryRowxContext(ctx, getUserQuery, userID).StructScan(user); err != nil {
+	if err := u.conn(ctx).QueryRowxContext(ctx, getUserQuery, userID).StructScan(user); err != nil {
 		return nil, errors.Wrap(err, "userRepo.GetByID.QueryRowxContext")
 	}
 
 	return user, nil
 }
 
-// Find users by name
-func (u *userRepo) FindByName(ctx context.Context, name string, pq *utils.PaginationQuery) (*models.UsersList, error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.FindByName")
+// Find which of emails are taken, soft deleted users keep their email until purged
+func (u *userRepo) FindExistingEmails(ctx context.Context, emails []string) ([]string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.FindExistingEmails")
 	defer span.Finish()
 
-	var totalCount int
-	if err := u.db.GetContext(ctx, &totalCount, getTotalCount, name); err != nil {
-		return nil, errors.Wrap(err, "userRepo.FindByName.GetContext.totalCount")
+	array := &pgtype.TextArray{}
+	if err := array.Set(emails); err != nil {
+		return nil, errors.Wrap(err, "userRepo.FindExistingEmails.Set")
 	}
 
-	if totalCount == 0 {
-		return &models.UsersList{
-			TotalCount: totalCount,
-			TotalPages: utils.GetTotalPages(totalCount, pq.GetSize()),
-			Page:       pq.GetPage(),
-			Size:       pq.GetSize(),
-			HasMore:    utils.GetHasMore(pq.GetPage(), totalCount, pq.GetSize()),
-			Users:      make([]*models.User, 0),
-		}, nil
+	existing := make([]string, 0)
+	if err := u.conn(ctx).SelectContext(ctx, &existing, findExistingEmailsQuery, array); err != nil {
+		return nil, errors.Wrap(err, "userRepo.FindExistingEmails.SelectContext")
 	}
 
-	rows, err := u.db.QueryxContext(ctx, findUsers, name, pq.GetOffset(), pq.GetSize())
+	return existing, nil
+}
+
+// Get users by ids with a single query, users not found are omitted and order is not kept
+func (u *userRepo) GetByIDs(ctx context.Context, userIDs []uuid.UUID) ([]*models.User, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.GetByIDs")
+	defer span.Finish()
+
+	ids := make([][16]byte, 0, len(userIDs))
+	for _, userID := range userIDs {
+		ids = append(ids, userID)
+	}
+	array := &pgtype.UUIDArray{}
+	if err := array.Set(ids); err != nil {
+		return nil, errors.Wrap(err, "userRepo.GetByIDs.Set")
+	}
+
+	users := make([]*models.User, 0, len(userIDs))
+	if err := u.conn(ctx).SelectContext(ctx, &users, getUsersByIDsQuery, array); err != nil {
+		return nil, errors.Wrap(err, "userRepo.GetByIDs.SelectContext")
+	}
+
+	return users, nil
+}
+
+// Get user by id and lock row until end of transaction
+func (u *userRepo) GetByIDForUpdate(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.GetByIDForUpdate")
+	defer span.Finish()
+
+	user := &models.User{}
+	if err := u.conn(ctx).QueryRowxContext(ctx, getUserForUpdateQuery, userID).StructScan(user); err != nil {
+		return nil, errors.Wrap(err, "userRepo.GetByIDForUpdate.QueryRowxContext")
+	}
+
+	return user, nil
+}
+
+// Find users by name
+func (u *userRepo) FindByName(ctx context.Context, name string, pq *utils.PaginationQuery) (*models.UsersList, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.FindByName")
+	defer span.Finish()
+
+	sort, err := parseSort(pq.GetOrderBy(), sortKey{column: firstNameColumn}, sortKey{column: lastNameColumn})
 	if err != nil {
-		return nil, errors.Wrap(err, "userRepo.FindByName.QueryxContext")
+		return nil, errors.Wrap(err, "userRepo.FindByName.parseSort")
 	}
-	defer rows.Close()
 
-	var users = make([]*models.User, 0, pq.GetSize())
-	for rows.Next() {
-		var user models.User
-		if err = rows.StructScan(&user); err != nil {
-			return nil, errors.Wrap(err, "userRepo.FindByName.StructScan")
-		}
-		users = append(users, &user)
+	q := newListQuery(sort...)
+	q.addUserFilter(nil)
+	q.addCondition(findUsersByNameCondition, name, name)
+
+	return u.listUsers(ctx, q, pq)
+}
+
+// Search users by full text and name or email similarity, ordered by relevance
+func (u *userRepo) SearchUsers(ctx context.Context, query string, pq *utils.PaginationQuery) (*models.UserSearchList, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.SearchUsers")
+	defer span.Finish()
+
+	var results = make([]*models.UserSearchResult, 0, pq.GetSize())
+	if err := u.conn(ctx).SelectContext(ctx, &results, searchUsersQuery, query, pq.GetOffset(), pq.GetLimit()+1); err != nil {
+		return nil, errors.Wrap(err, "userRepo.SearchUsers.SelectContext")
 	}
 
-	if err = rows.Err(); err != nil {
-		return nil, errors.Wrap(err, "userRepo.FindByName.rows.Err")
+	hasMore := len(results) > pq.GetLimit()
+	if hasMore {
+		results = results[:pq.GetLimit()]
 	}
 
-	return &models.UsersList{
-		TotalCount: totalCount,
-		TotalPages: utils.GetTotalPages(totalCount, pq.GetSize()),
-		Page:       pq.GetPage(),
-		Size:       pq.GetSize(),
-		HasMore:    utils.GetHasMore(pq.GetPage(), totalCount, pq.GetSize()),
-		Users:      users,
+	return &models.UserSearchList{
+		Page:    pq.GetPage(),
+		Size:    pq.GetSize(),
+		HasMore: hasMore,
+		Results: results,
 	}, nil
 }
 
@@ -144,53 +262,66 @@ func (u *userRepo) FindByEmail(ctx context.Context, email string) (*models.User,
 	defer span.Finish()
 
 	foundUser := &models.User{}
-	if err := u.db.QueryRowxContext(ctx, findUserByEmail, email).StructScan(foundUser); err != nil {
+	if err := u.conn(ctx).QueryRowxContext(ctx, findUserByEmail, email).StructScan(foundUser); err != nil {
 		return nil, errors.Wrap(err, "userRepo.FindByEmail.QueryRowxContext")
 	}
 	return foundUser, nil
 }
 
-// Get users with pagination
-func (u *userRepo) GetUsers(ctx context.Context, pq *utils.PaginationQuery) (*models.UsersList, error) {
+// Get users matching filter with pagination
+func (u *userRepo) GetUsers(ctx context.Context, filter *models.UserFilter, pq *utils.PaginationQuery) (*models.UsersList, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.GetUsers")
 	defer span.Finish()
 
-	var totalCount int
-	if err := u.db.GetContext(ctx, &totalCount, getTotal); err != nil {
-		return nil, errors.Wrap(err, "userRepo.GetUsers.GetContext.totalCount")
-	}
-
-	if totalCount == 0 {
-		return &models.UsersList{
-			TotalCount: totalCount,
-			TotalPages: utils.GetTotalPages(totalCount, pq.GetSize()),
-			Page:       pq.GetPage(),
-			Size:       pq.GetSize(),
-			HasMore:    utils.GetHasMore(pq.GetPage(), totalCount, pq.GetSize()),
-			Users:      make([]*models.User, 0),
-		}, nil
-	}
-
-	var users = make([]*models.User, 0, pq.GetSize())
-	if err := u.db.SelectContext(
-		ctx,
-		&users,
-		getUsers,
-		pq.GetOrderBy(),
-		pq.GetOffset(),
-		pq.GetLimit(),
-	); err != nil {
-		return nil, errors.Wrap(err, "userRepo.GetUsers.SelectContext")
-	}
-
-	return &models.UsersList{
-		TotalCount: totalCount,
-		TotalPages: utils.GetTotalPages(totalCount, pq.GetSize()),
-		Page:       pq.GetPage(),
-		Size:       pq.GetSize(),
-		HasMore:    utils.GetHasMore(pq.GetPage(), totalCount, pq.GetSize()),
-		Users:      users,
-	}, nil
+	sort, err := parseSort(pq.GetOrderBy(), sortKey{column: firstNameColumn})
+	if err != nil {
+		return nil, errors.Wrap(err, "userRepo.GetUsers.parseSort")
+	}
+
+	q := newListQuery(sort...)
+	q.addUserFilter(filter)
+
+	return u.listUsers(ctx, q, pq)
+}
+
+// Walk users matching filter with a cursor in a read only repeatable read snapshot, fn is called for every user
+func (u *userRepo) ExportUsers(ctx context.Context, filter *models.UserFilter, fn func(user *models.User) error) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.ExportUsers")
+	defer span.Finish()
+
+	tx, err := u.db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return errors.Wrap(err, "userRepo.ExportUsers.BeginTxx")
+	}
+	defer tx.Rollback()
+
+	q := newListQuery()
+	q.addUserFilter(filter)
+	query, args := q.exportSQL()
+	if _, err = tx.ExecContext(ctx, declareExportCursorQuery+query, args...); err != nil {
+		return errors.Wrap(err, "userRepo.ExportUsers.ExecContext")
+	}
+
+	for {
+		users := make([]*models.User, 0, exportFetchSize)
+		if err = tx.SelectContext(ctx, &users, fmt.Sprintf(fetchExportCursorQuery, exportFetchSize)); err != nil {
+			return errors.Wrap(err, "userRepo.ExportUsers.SelectContext")
+		}
+		for _, user := range users {
+			if err = fn(user); err != nil {
+				return err
+			}
+		}
+		if len(users) < exportFetchSize {
+			break
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "userRepo.ExportUsers.Commit")
+	}
+
+	return nil
 }
 
 // Update existing user role
@@ -199,8 +330,212 @@ func (u *userRepo) UpdateRole(ctx context.Context, user *models.User) (*models.U
 	defer span.Finish()
 
 	updatedUser := &models.User{}
-	if err := u.db.GetContext(ctx, updatedUser, updateUserRoleQuery, &user.Role, &user.UserID); err != nil {
+	if err := u.conn(ctx).GetContext(ctx, updatedUser, updateUserRoleQuery, &user.Role, &user.UserID); err != nil {
 		return nil, errors.Wrap(err, "userRepo.UpdateRole.GetContext")
 	}
 	return updatedUser, nil
 }
+
+// Update existing user avatar
+func (u *userRepo) UpdateAvatar(ctx context.Context, userID uuid.UUID, avatar *string) (*models.User, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.UpdateAvatar")
+	defer span.Finish()
+
+	updatedUser := &models.User{}
+	if err := u.conn(ctx).GetContext(ctx, updatedUser, updateUserAvatarQuery, avatar, userID); err != nil {
+		return nil, errors.Wrap(err, "userRepo.UpdateAvatar.GetContext")
+	}
+	return updatedUser, nil
+}
+
+// Update existing user password, password must be already hashed
+func (u *userRepo) UpdatePassword(ctx context.Context, userID uuid.UUID, password string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.UpdatePassword")
+	defer span.Finish()
+
+	result, err := u.conn(ctx).ExecContext(ctx, updateUserPasswordQuery, password, userID)
+	if err != nil {
+		return errors.Wrap(err, "userRepo.UpdatePassword.ExecContext")
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "userRepo.UpdatePassword.RowsAffected")
+	}
+	if rowsAffected == 0 {
+		return errors.Wrap(sql.ErrNoRows, "userRepo.UpdatePassword.rowsAffected")
+	}
+
+	return nil
+}
+
+// Mark user email as verified, only while user still has the given email
+func (u *userRepo) VerifyEmail(ctx context.Context, userID uuid.UUID, email string) (*models.User, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.VerifyEmail")
+	defer span.Finish()
+
+	updatedUser := &models.User{}
+	if err := u.conn(ctx).GetContext(ctx, updatedUser, verifyUserEmailQuery, userID, email); err != nil {
+		return nil, errors.Wrap(err, "userRepo.VerifyEmail.GetContext")
+	}
+	return updatedUser, nil
+}
+
+// Create pending TOTP secret with recovery codes, replaces previous pending enrollment
+func (u *userRepo) CreateTOTP(ctx context.Context, userID uuid.UUID, secret string, recoveryCodeHashes []string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.CreateTOTP")
+	defer span.Finish()
+
+	return postgres.WithinTransaction(ctx, u.db, func(ctx context.Context) error {
+		result, err := u.conn(ctx).ExecContext(ctx, upsertTOTPQuery, userID, secret)
+		if err != nil {
+			return errors.Wrap(err, "userRepo.CreateTOTP.ExecContext")
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, "userRepo.CreateTOTP.RowsAffected")
+		}
+		if rowsAffected == 0 {
+			return grpc_errors.ErrMFAEnabled
+		}
+
+		if _, err = u.conn(ctx).ExecContext(ctx, deleteRecoveryCodesQuery, userID); err != nil {
+			return errors.Wrap(err, "userRepo.CreateTOTP.ExecContext.deleteRecoveryCodes")
+		}
+		for _, codeHash := range recoveryCodeHashes {
+			if _, err = u.conn(ctx).ExecContext(ctx, createRecoveryCodeQuery, userID, codeHash); err != nil {
+				return errors.Wrap(err, "userRepo.CreateTOTP.ExecContext.createRecoveryCode")
+			}
+		}
+
+		return nil
+	})
+}
+
+// Get user TOTP
+func (u *userRepo) GetTOTP(ctx context.Context, userID uuid.UUID) (*models.TOTP, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.GetTOTP")
+	defer span.Finish()
+
+	totp := &models.TOTP{}
+	if err := u.conn(ctx).GetContext(ctx, totp, getTOTPQuery, userID); err != nil {
+		return nil, errors.Wrap(err, "userRepo.GetTOTP.GetContext")
+	}
+	return totp, nil
+}
+
+// Enable pending user TOTP
+func (u *userRepo) EnableTOTP(ctx context.Context, userID uuid.UUID) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.EnableTOTP")
+	defer span.Finish()
+
+	result, err := u.conn(ctx).ExecContext(ctx, enableTOTPQuery, userID)
+	if err != nil {
+		return errors.Wrap(err, "userRepo.EnableTOTP.ExecContext")
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "userRepo.EnableTOTP.RowsAffected")
+	}
+	if rowsAffected == 0 {
+		return errors.Wrap(sql.ErrNoRows, "userRepo.EnableTOTP.rowsAffected")
+	}
+
+	return nil
+}
+
+// Delete user TOTP and recovery codes
+func (u *userRepo) DeleteTOTP(ctx context.Context, userID uuid.UUID) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.DeleteTOTP")
+	defer span.Finish()
+
+	return postgres.WithinTransaction(ctx, u.db, func(ctx context.Context) error {
+		if _, err := u.conn(ctx).ExecContext(ctx, deleteTOTPQuery, userID); err != nil {
+			return errors.Wrap(err, "userRepo.DeleteTOTP.ExecContext")
+		}
+		if _, err := u.conn(ctx).ExecContext(ctx, deleteRecoveryCodesQuery, userID); err != nil {
+			return errors.Wrap(err, "userRepo.DeleteTOTP.ExecContext.deleteRecoveryCodes")
+		}
+		return nil
+	})
+}
+
+// Mark unused recovery code as used
+func (u *userRepo) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.UseRecoveryCode")
+	defer span.Finish()
+
+	result, err := u.conn(ctx).ExecContext(ctx, useRecoveryCodeQuery, userID, codeHash)
+	if err != nil {
+		return errors.Wrap(err, "userRepo.UseRecoveryCode.ExecContext")
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "userRepo.UseRecoveryCode.RowsAffected")
+	}
+	if rowsAffected == 0 {
+		return grpc_errors.ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+// List users page by page token when set, otherwise by page number; total count is skipped on request
+func (u *userRepo) listUsers(ctx context.Context, q *listQuery, pq *utils.PaginationQuery) (*models.UsersList, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRepo.listUsers")
+	defer span.Finish()
+
+	var cursor *pageCursor
+	if pq.GetPageToken() != "" {
+		var err error
+		if cursor, err = q.decodeCursor(pq.GetPageToken()); err != nil {
+			return nil, errors.Wrap(err, "userRepo.listUsers.decodeCursor")
+		}
+	}
+
+	usersList := &models.UsersList{
+		Page:  pq.GetPage(),
+		Size:  pq.GetSize(),
+		Users: make([]*models.User, 0, pq.GetSize()),
+	}
+	if cursor != nil {
+		usersList.Page = 0
+	}
+
+	if !pq.GetSkipTotalCount() {
+		countQuery, countArgs := q.countSQL()
+		if err := u.conn(ctx).GetContext(ctx, &usersList.TotalCount, countQuery, countArgs...); err != nil {
+			return nil, errors.Wrap(err, "userRepo.listUsers.GetContext.totalCount")
+		}
+		usersList.TotalPages = utils.GetTotalPages(usersList.TotalCount, pq.GetSize())
+	}
+
+	query, args := q.selectSQL(cursor, pq.GetOffset(), pq.GetLimit()+1)
+	if err := u.conn(ctx).SelectContext(ctx, &usersList.Users, query, args...); err != nil {
+		return nil, errors.Wrap(err, "userRepo.listUsers.SelectContext")
+	}
+
+	hasMore := len(usersList.Users) > pq.GetLimit()
+	if hasMore {
+		usersList.Users = usersList.Users[:pq.GetLimit()]
+	}
+
+	hasNext, hasPrev := hasMore, cursor != nil || pq.GetOffset() > 0
+	if cursor != nil && cursor.Prev {
+		for i, j := 0, len(usersList.Users)-1; i < j; i, j = i+1, j-1 {
+			usersList.Users[i], usersList.Users[j] = usersList.Users[j], usersList.Users[i]
+		}
+		hasNext, hasPrev = true, hasMore
+	}
+
+	if n := len(usersList.Users); n > 0 {
+		if hasNext {
+			usersList.NextPageToken = q.cursorFor(usersList.Users[n-1], false)
+		}
+		if hasPrev {
+			usersList.PrevPageToken = q.cursorFor(usersList.Users[0], true)
+		}
+	}
+	usersList.HasMore = hasNext
+
+	return usersList, nil
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+
+	"github.com/JamesHsu333/go-grpc/config"
+	"github.com/JamesHsu333/go-grpc/internal/models"
+	"github.com/JamesHsu333/go-grpc/internal/user"
+	"github.com/JamesHsu333/go-grpc/pkg/logger"
+)
+
+// Events kept by memory publisher, oldest are dropped first
+const memoryPublisherCapacity = 1000
+
+// Event publisher keeping latest published events in memory, for development and tests
+type userEventMemoryPublisher struct {
+	mu     sync.Mutex
+	events []*models.OutboxEvent
+	logger logger.Logger
+}
+
+// Event memory publisher constructor
+func NewUserEventMemoryPublisher(logger logger.Logger) user.EventPublisher {
+	return &userEventMemoryPublisher{logger: logger}
+}
+
+// Keep event in memory
+func (p *userEventMemoryPublisher) Publish(ctx context.Context, event *models.OutboxEvent) error {
+	span, _ := opentracing.StartSpanFromContext(ctx, "userEventMemoryPublisher.Publish")
+	defer span.Finish()
+
+	published := *event
+	p.mu.Lock()
+	if len(p.events) == memoryPublisherCapacity {
+		p.events = p.events[1:]
+	}
+	p.events = append(p.events, &published)
+	p.mu.Unlock()
+
+	p.logger.Debugf("Event %s %s of %s published", event.EventID, event.Type, event.AggregateID)
+	return nil
+}
+
+// Get published events, oldest first
+func (p *userEventMemoryPublisher) Events() []*models.OutboxEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	events := make([]*models.OutboxEvent, len(p.events))
+	copy(events, p.events)
+	return events
+}
+
+// Event publisher appending events to redis stream
+type userEventRedisPublisher struct {
+	redisClient *redis.Client
+	cfg         *config.Config
+}
+
+// Event redis streams publisher constructor
+func NewUserEventRedisPublisher(redisClient *redis.Client, cfg *config.Config) user.EventPublisher {
+	return &userEventRedisPublisher{redisClient: redisClient, cfg: cfg}
+}
+
+// Append event to stream, stream is trimmed approximately to configured length
+func (p *userEventRedisPublisher) Publish(ctx context.Context, event *models.OutboxEvent) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userEventRedisPublisher.Publish")
+	defer span.Finish()
+
+	if err := p.redisClient.XAdd(ctx, &redis.XAddArgs{
+		Stream: p.cfg.Outbox.Stream,
+		MaxLen: p.cfg.Outbox.StreamMaxLen,
+		Approx: p.cfg.Outbox.StreamMaxLen > 0,
+		Values: map[string]interface{}{
+			"event_id":     event.EventID.String(),
+			"type":         event.Type,
+			"aggregate_id": event.AggregateID.String(),
+			"payload":      string(event.Payload),
+			"created_at":   event.CreatedAt.Format(time.RFC3339Nano),
+		},
+	}).Err(); err != nil {
+		return errors.Wrap(err, "userEventRedisPublisher.Publish.XAdd")
+	}
+
+	return nil
+}
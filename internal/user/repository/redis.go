@@ -11,9 +11,30 @@ import (
 	"github.com/JamesHsu333/go-grpc/pkg/grpc_errors"
 	"github.com/JamesHsu333/go-grpc/pkg/logger"
 	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
 	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
 )
 
+// Increment counter and set its ttl in one step, counter left without ttl gets one too
+var incrCounterScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 or redis.call("TTL", KEYS[1]) < 0 then
+	redis.call("EXPIRE", KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// Set key unless it already holds greater or equal value
+var setGreaterScript = redis.NewScript(`
+local current = tonumber(redis.call("GET", KEYS[1]))
+if current and current >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[1], "EX", ARGV[2])
+return 1
+`)
+
 // Auth redis repository
 type userRedisRepo struct {
 	redisClient *redis.Client
@@ -26,37 +47,95 @@ func NewUserRedisRepo(redisClient *redis.Client, logger logger.Logger) user.Redi
 	return &userRedisRepo{redisClient: redisClient, basePrefix: "user:", logger: logger}
 }
 
-// Get user by id
-func (u *userRedisRepo) GetByIDCtx(ctx context.Context, key string) (*models.User, error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "userRedisRepo.GetByIDCtx")
+// Get cached user entry, nil when not cached
+func (u *userRedisRepo) GetUserEntryCtx(ctx context.Context, key string) (*models.UserCacheEntry, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRedisRepo.GetUserEntryCtx")
 	defer span.Finish()
 
-	userBytes, err := u.redisClient.Get(ctx, u.createKey(key)).Bytes()
+	entryBytes, err := u.redisClient.Get(ctx, u.createKey(key)).Bytes()
 	if err != nil {
-		if err != redis.Nil {
-			return nil, grpc_errors.ErrNotFound
+		if err == redis.Nil {
+			return nil, nil
 		}
 		return nil, err
 	}
-	user := &models.User{}
-	if err = json.Unmarshal(userBytes, user); err != nil {
+	entry := &models.UserCacheEntry{}
+	if err = json.Unmarshal(entryBytes, entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// Get user entries by keys with single MGET, result is in keys order and entries not cached are nil
+func (u *userRedisRepo) GetUserEntriesCtx(ctx context.Context, keys []string) ([]*models.UserCacheEntry, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRedisRepo.GetUserEntriesCtx")
+	defer span.Finish()
+
+	redisKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		redisKeys = append(redisKeys, u.createKey(key))
+	}
+
+	values, err := u.redisClient.MGet(ctx, redisKeys...).Result()
+	if err != nil {
 		return nil, err
 	}
 
-	return user, nil
+	entries := make([]*models.UserCacheEntry, len(keys))
+	for i, value := range values {
+		entryJSON, ok := value.(string)
+		if !ok {
+			continue
+		}
+		entry := &models.UserCacheEntry{}
+		if err = json.Unmarshal([]byte(entryJSON), entry); err != nil {
+			u.logger.Errorf("userRedisRepo.GetUserEntriesCtx.Unmarshal: %v", err)
+			continue
+		}
+		entries[i] = entry
+	}
+
+	return entries, nil
 }
 
-// Cache user with duration in seconds
-func (u *userRedisRepo) SetUserCtx(ctx context.Context, key string, seconds int, user *models.User) error {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "userRedisRepo.SetUserCtx")
+// Cache user entry until its expiry, expired entry is not cached
+func (u *userRedisRepo) SetUserEntryCtx(ctx context.Context, key string, entry *models.UserCacheEntry) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRedisRepo.SetUserEntryCtx")
 	defer span.Finish()
 
-	userBytes, err := json.Marshal(user)
+	ttl := time.Until(entry.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	entryBytes, err := json.Marshal(entry)
 	if err != nil {
 		return err
 	}
 
-	return u.redisClient.Set(ctx, u.createKey(key), userBytes, time.Second*time.Duration(seconds)).Err()
+	return u.redisClient.Set(ctx, u.createKey(key), entryBytes, ttl).Err()
+}
+
+// Cache user entries keyed by cache key in one pipeline, every entry until its expiry
+func (u *userRedisRepo) SetUserEntriesCtx(ctx context.Context, entries map[string]*models.UserCacheEntry) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRedisRepo.SetUserEntriesCtx")
+	defer span.Finish()
+
+	pipe := u.redisClient.Pipeline()
+	for key, entry := range entries {
+		ttl := time.Until(entry.ExpiresAt)
+		if ttl <= 0 {
+			continue
+		}
+		entryBytes, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		pipe.Set(ctx, u.createKey(key), entryBytes, ttl)
+	}
+
+	_, err := pipe.Exec(ctx)
+	return err
 }
 
 // Delete user by key
@@ -67,6 +146,189 @@ func (u *userRedisRepo) DeleteUserCtx(ctx context.Context, key string) error {
 	return u.redisClient.Del(ctx, u.createKey(key)).Err()
 }
 
+// Store single use token owned by user with duration in seconds
+func (u *userRedisRepo) SetTokenCtx(ctx context.Context, key string, seconds int, userID uuid.UUID) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRedisRepo.SetTokenCtx")
+	defer span.Finish()
+
+	return u.redisClient.Set(ctx, u.createKey(key), userID.String(), time.Second*time.Duration(seconds)).Err()
+}
+
+// Get token owner and delete token, so it can be used only once
+func (u *userRedisRepo) PopTokenCtx(ctx context.Context, key string) (uuid.UUID, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRedisRepo.PopTokenCtx")
+	defer span.Finish()
+
+	var get *redis.StringCmd
+	if _, err := u.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, u.createKey(key))
+		pipe.Del(ctx, u.createKey(key))
+		return nil
+	}); err != nil {
+		if err == redis.Nil {
+			return uuid.Nil, grpc_errors.ErrInvalidToken
+		}
+		return uuid.Nil, err
+	}
+
+	return uuid.Parse(get.Val())
+}
+
+// Store single use token owned by user with duration in seconds, previous token of the same owner key
+// is deleted so only the latest issued token is valid
+func (u *userRedisRepo) SetOwnedTokenCtx(ctx context.Context, key string, ownerKey string, seconds int, userID uuid.UUID) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRedisRepo.SetOwnedTokenCtx")
+	defer span.Finish()
+
+	return u.setOwned(ctx, key, ownerKey, seconds, userID.String())
+}
+
+// Delete latest token issued for owner key
+func (u *userRedisRepo) DeleteOwnedTokenCtx(ctx context.Context, ownerKey string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRedisRepo.DeleteOwnedTokenCtx")
+	defer span.Finish()
+
+	key, err := u.redisClient.Get(ctx, u.createKey(ownerKey)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil
+		}
+		return err
+	}
+
+	return u.redisClient.Del(ctx, u.createKey(key), u.createKey(ownerKey)).Err()
+}
+
+// Store email token with duration in seconds, previous token of the same owner key is deleted
+// so only the latest issued token is valid
+func (u *userRedisRepo) SetEmailTokenCtx(ctx context.Context, key string, ownerKey string, seconds int, token *models.EmailToken) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRedisRepo.SetEmailTokenCtx")
+	defer span.Finish()
+
+	tokenBytes, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	return u.setOwned(ctx, key, ownerKey, seconds, tokenBytes)
+}
+
+// Store value under key and key under owner key, key previously stored under owner key is deleted
+func (u *userRedisRepo) setOwned(ctx context.Context, key string, ownerKey string, seconds int, value interface{}) error {
+	var prev *redis.StringCmd
+	if _, err := u.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		prev = pipe.GetSet(ctx, u.createKey(ownerKey), key)
+		pipe.Expire(ctx, u.createKey(ownerKey), time.Second*time.Duration(seconds))
+		pipe.Set(ctx, u.createKey(key), value, time.Second*time.Duration(seconds))
+		return nil
+	}); err != nil && err != redis.Nil {
+		return err
+	}
+
+	if prevKey := prev.Val(); prevKey != "" && prevKey != key {
+		return u.redisClient.Del(ctx, u.createKey(prevKey)).Err()
+	}
+	return nil
+}
+
+// Get email token and delete it, so it can be used only once
+func (u *userRedisRepo) PopEmailTokenCtx(ctx context.Context, key string) (*models.EmailToken, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRedisRepo.PopEmailTokenCtx")
+	defer span.Finish()
+
+	var get *redis.StringCmd
+	if _, err := u.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, u.createKey(key))
+		pipe.Del(ctx, u.createKey(key))
+		return nil
+	}); err != nil {
+		if err == redis.Nil {
+			return nil, grpc_errors.ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	token := &models.EmailToken{}
+	if err := json.Unmarshal([]byte(get.Val()), token); err != nil {
+		return nil, errors.Wrap(grpc_errors.ErrInvalidToken, err.Error())
+	}
+	return token, nil
+}
+
+// Increment counter, window of duration in seconds starts with first increment
+func (u *userRedisRepo) IncrCounterCtx(ctx context.Context, key string, seconds int) (int64, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRedisRepo.IncrCounterCtx")
+	defer span.Finish()
+
+	return incrCounterScript.Run(ctx, u.redisClient, []string{u.createKey(key)}, seconds).Int64()
+}
+
+// Get counter value, missing counter is zero
+func (u *userRedisRepo) GetCounterCtx(ctx context.Context, key string) (int64, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRedisRepo.GetCounterCtx")
+	defer span.Finish()
+
+	count, err := u.redisClient.Get(ctx, u.createKey(key)).Int64()
+	if err != nil {
+		if err == redis.Nil {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// Set value with duration in seconds when it is greater than current one, returns false otherwise
+func (u *userRedisRepo) SetGreaterCtx(ctx context.Context, key string, value int64, seconds int) (bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRedisRepo.SetGreaterCtx")
+	defer span.Finish()
+
+	set, err := setGreaterScript.Run(ctx, u.redisClient, []string{u.createKey(key)}, value, seconds).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return set == 1, nil
+}
+
+// Set lock with duration in seconds
+func (u *userRedisRepo) LockCtx(ctx context.Context, key string, seconds int) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRedisRepo.LockCtx")
+	defer span.Finish()
+
+	return u.redisClient.Set(ctx, u.createKey(key), time.Now().UTC().Unix(), time.Second*time.Duration(seconds)).Err()
+}
+
+// Get time left until lock expires, zero if not locked
+func (u *userRedisRepo) GetLockTTLCtx(ctx context.Context, key string) (time.Duration, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRedisRepo.GetLockTTLCtx")
+	defer span.Finish()
+
+	ttl, err := u.redisClient.PTTL(ctx, u.createKey(key)).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+
+	return ttl, nil
+}
+
+// Delete keys
+func (u *userRedisRepo) DeleteKeysCtx(ctx context.Context, keys ...string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userRedisRepo.DeleteKeysCtx")
+	defer span.Finish()
+
+	redisKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		redisKeys = append(redisKeys, u.createKey(key))
+	}
+
+	return u.redisClient.Del(ctx, redisKeys...).Err()
+}
+
 func (r *userRedisRepo) createKey(value string) string {
 	return fmt.Sprintf("%s: %s", r.basePrefix, value)
 }
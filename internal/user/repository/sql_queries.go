@@ -2,61 +2,142 @@ package repository
 
 const (
 	createUserQuery = `INSERT INTO users (first_name, last_name, email, password, role, about, avatar, phone_number, address,
-	               		city, gender, postcode, birthday, created_at, updated_at, login_date)
-						VALUES ($1, $2, $3, $4, COALESCE(NULLIF($5, ''), 'user'), $6, $7, $8, $9, $10, $11, $12, $13, now(), now(), now()) 
+	               		city, gender, postcode, birthday, country, created_at, updated_at, login_date)
+						VALUES ($1, $2, $3, $4, COALESCE(NULLIF($5, ''), 'user'), $6, $7, $8, $9, $10, $11, $12, $13, $14, now(), now(), now()) 
 						RETURNING *`
 
-	updateUserQuery = `UPDATE users 
-						SET first_name = COALESCE(NULLIF($1, ''), first_name),
-						    last_name = COALESCE(NULLIF($2, ''), last_name),
-						    email = COALESCE(NULLIF($3, ''), email),
-						    about = COALESCE(NULLIF($4, ''), about),
-						    avatar = COALESCE(NULLIF($5, ''), avatar),
-						    phone_number = COALESCE(NULLIF($6, ''), phone_number),
-						    address = COALESCE(NULLIF($7, ''), address),
-						    city = COALESCE(NULLIF($8, ''), city),
-						    gender = COALESCE(NULLIF($9, ''), gender),
-						    postcode = COALESCE(NULLIF($10, 0), postcode),
-						    birthday = COALESCE(NULLIF($11, '')::date, birthday),
-						    updated_at = now()
-						WHERE user_id = $12
-						RETURNING *
-						`
-
-	deleteUserQuery = `DELETE FROM users WHERE user_id = $1`
-
-	getUserQuery = `SELECT user_id, first_name, last_name, email, role, about, avatar, phone_number, 
-       				 address, city, gender, postcode, birthday, created_at, updated_at, login_date  
+	updateUserQuery = `UPDATE users SET %s WHERE user_id = $%d AND deleted_at IS NULL RETURNING *`
+
+	deleteUserQuery = `UPDATE users SET deleted_at = now(), version = version + 1 WHERE user_id = $1 AND deleted_at IS NULL`
+
+	restoreUserQuery = `UPDATE users SET deleted_at = NULL, updated_at = now(), version = version + 1 
+						WHERE user_id = $1 AND deleted_at IS NOT NULL 
+						RETURNING *`
+
+	purgeUsersQuery = `DELETE FROM users WHERE deleted_at < $1 RETURNING avatar`
+
+	tryLockPurgeQuery = `SELECT pg_try_advisory_xact_lock($1)`
+
+	getUserQuery = `SELECT user_id, first_name, last_name, email, email_verified, role, about, avatar, phone_number, 
+       				 address, city, country, gender, postcode, birthday, created_at, updated_at, login_date, version
 					 FROM users 
-					 WHERE user_id = $1`
+					 WHERE user_id = $1 AND deleted_at IS NULL`
+
+	findExistingEmailsQuery = `SELECT email FROM users WHERE email = ANY($1)`
+
+	getUsersByIDsQuery = `SELECT user_id, first_name, last_name, email, email_verified, role, about, avatar, phone_number, 
+       				 	  address, city, country, gender, postcode, birthday, created_at, updated_at, login_date, version
+					 	  FROM users 
+					 	  WHERE user_id = ANY($1) AND deleted_at IS NULL`
 
 	updateUserRoleQuery = `UPDATE users 
 							SET role = COALESCE(NULLIF($1, ''), role),
-								updated_at = now()
-							WHERE user_id = $2
+								updated_at = now(), version = version + 1
+							WHERE user_id = $2 AND deleted_at IS NULL
 							RETURNING *
 							`
 
-	getTotalCount = `SELECT COUNT(user_id) FROM users 
-						WHERE first_name ILIKE '%' || $1 || '%' or last_name ILIKE '%' || $1 || '%'`
+	updateUserAvatarQuery = `UPDATE users 
+							SET avatar = $1,
+								updated_at = now(), version = version + 1
+							WHERE user_id = $2 AND deleted_at IS NULL
+							RETURNING *
+							`
+
+	verifyUserEmailQuery = `UPDATE users SET email_verified = true, updated_at = now(), version = version + 1 WHERE user_id = $1 AND email = $2 AND deleted_at IS NULL RETURNING *`
+
+	updateUserPasswordQuery = `UPDATE users SET password = $1, updated_at = now(), version = version + 1 WHERE user_id = $2 AND deleted_at IS NULL`
+
+	upsertTOTPQuery = `INSERT INTO user_totp (user_id, secret, enabled, created_at)
+						VALUES ($1, $2, false, now())
+						ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, enabled = false, 
+						created_at = now(), confirmed_at = NULL
+						WHERE user_totp.enabled = false`
+
+	getTOTPQuery = `SELECT user_id, secret, enabled, created_at, confirmed_at FROM user_totp WHERE user_id = $1`
+
+	enableTOTPQuery = `UPDATE user_totp SET enabled = true, confirmed_at = now() WHERE user_id = $1 AND enabled = false`
+
+	deleteTOTPQuery = `DELETE FROM user_totp WHERE user_id = $1`
+
+	deleteRecoveryCodesQuery = `DELETE FROM user_recovery_codes WHERE user_id = $1`
+
+	createRecoveryCodeQuery = `INSERT INTO user_recovery_codes (user_id, code_hash, created_at) VALUES ($1, $2, now())`
+
+	useRecoveryCodeQuery = `UPDATE user_recovery_codes SET used_at = now() 
+							WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+
+	countUsersQuery = `SELECT COUNT(user_id) FROM users`
+
+	listUsersQuery = `SELECT user_id, first_name, last_name, email, email_verified, role, about, avatar, phone_number, 
+       			 	   address, city, country, gender, postcode, birthday, created_at, updated_at, login_date, deleted_at, version
+				 	   FROM users`
+
+	declareExportCursorQuery = `DECLARE export_users NO SCROLL CURSOR FOR `
+
+	fetchExportCursorQuery = `FETCH FORWARD %d FROM export_users`
+
+	findUsersByNameCondition = `(first_name ILIKE '%%' || %s || '%%' OR last_name ILIKE '%%' || %s || '%%')`
+
+	searchUsersQuery = `SELECT user_id, first_name, last_name, email, email_verified, role, about, avatar, phone_number, 
+       			 		address, city, country, gender, postcode, birthday, created_at, updated_at, login_date, version,
+       			 		ts_rank_cd(users_search_document(first_name, last_name, email, city, about), query) * 2 +
+       			 		GREATEST(similarity(first_name || ' ' || last_name, $1), similarity(email, $1)) AS rank,
+       			 		ts_headline('simple', concat_ws(' ', first_name, last_name, email, city, about), query,
+       			 		'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MinWords=3, MaxWords=15') AS highlight
+				 		FROM users, (SELECT websearch_to_tsquery('simple', $1) || websearch_to_tsquery('english', $1) AS query) AS search
+				 		WHERE deleted_at IS NULL
+				 		  AND (users_search_document(first_name, last_name, email, city, about) @@ query
+				 		   OR (first_name || ' ' || last_name) % $1
+				 		   OR email % $1)
+				 		ORDER BY rank DESC, user_id
+				 		OFFSET $2 LIMIT $3`
+
+	getUserForUpdateQuery = `SELECT user_id, first_name, last_name, email, email_verified, role, about, avatar, phone_number, 
+       				 		  address, city, country, gender, postcode, birthday, created_at, updated_at, login_date, version
+					 		  FROM users 
+					 		  WHERE user_id = $1 AND deleted_at IS NULL
+					 		  FOR UPDATE`
+
+	createAuditEventQuery = `INSERT INTO audit_events (actor_id, target_id, action, changes, ip_address, created_at)
+							VALUES ($1, $2, $3, $4, $5, now())`
+
+	listAuditEventsQuery = `SELECT event_id, actor_id, target_id, action, changes, ip_address, created_at FROM audit_events`
+
+	createOutboxEventQuery = `INSERT INTO outbox_events (aggregate_id, event_type, payload, created_at, next_attempt_at)
+								VALUES ($1, $2, $3, now(), now())`
+
+	// Only the oldest unpublished event of aggregate is due, later ones wait for it to be published
+	lockPendingOutboxEventsQuery = `SELECT event_id, aggregate_id, event_type, payload, created_at, published_at, failed_at,
+       									attempts, last_error, next_attempt_at
+									FROM outbox_events e
+									WHERE published_at IS NULL AND failed_at IS NULL AND next_attempt_at <= now()
+										AND NOT EXISTS(SELECT 1 FROM outbox_events p
+											WHERE p.aggregate_id = e.aggregate_id AND p.published_at IS NULL
+											AND (p.created_at, p.event_id) < (e.created_at, e.event_id))
+									ORDER BY created_at, event_id
+									LIMIT $1
+									FOR UPDATE SKIP LOCKED`
+
+	markOutboxEventPublishedQuery = `UPDATE outbox_events SET published_at = now(), attempts = attempts + 1, last_error = '' 
+									WHERE event_id = $1`
+
+	markOutboxEventFailedQuery = `UPDATE outbox_events SET attempts = attempts + 1, last_error = $1, next_attempt_at = $2 
+								WHERE event_id = $3`
+
+	markOutboxEventDeadLetterQuery = `UPDATE outbox_events SET attempts = $1, last_error = $2, failed_at = now() WHERE event_id = $3`
+
+	deletePublishedOutboxEventsQuery = `DELETE FROM outbox_events WHERE published_at < $1`
 
-	findUsers = `SELECT user_id, first_name, last_name, email, role, about, avatar, phone_number, address,
-	              city, gender, postcode, birthday, created_at, updated_at, login_date 
-				  FROM users 
-				  WHERE first_name ILIKE '%' || $1 || '%' or last_name ILIKE '%' || $1 || '%'
-				  ORDER BY first_name, last_name
-				  OFFSET $2 LIMIT $3
-				  `
+	listUserChangesQuery = `SELECT change_id, txid, user_id, change_type, changed_at FROM user_changes`
 
-	getTotal = `SELECT COUNT(user_id) FROM users`
+	// Changes of transactions below snapshot xmin are committed or aborted, later ones may still commit out of order
+	userChangesHorizonQuery = `SELECT txid_snapshot_xmin(txid_current_snapshot())`
 
-	getUsers = `SELECT user_id, first_name, last_name, email, role, about, avatar, phone_number, 
-       			 address, city, gender, postcode, birthday, created_at, updated_at, login_date
-				 FROM users 
-				 ORDER BY COALESCE(NULLIF($1, ''), first_name) OFFSET $2 LIMIT $3`
+	deleteUserChangesQuery = `DELETE FROM user_changes WHERE changed_at < $1`
 
-	findUserByEmail = `SELECT user_id, first_name, last_name, email, role, about, avatar, phone_number, 
-       			 		address, city, gender, postcode, birthday, created_at, updated_at, login_date, password
+	findUserByEmail = `SELECT user_id, first_name, last_name, email, email_verified, role, about, avatar, phone_number, 
+       			 		address, city, country, gender, postcode, birthday, created_at, updated_at, login_date, version, password
 				 		FROM users 
-				 		WHERE email = $1`
+				 		WHERE email = $1 AND deleted_at IS NULL`
 )
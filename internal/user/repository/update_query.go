@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/JamesHsu333/go-grpc/internal/models"
+	"github.com/JamesHsu333/go-grpc/pkg/grpc_errors"
+	"github.com/JamesHsu333/go-grpc/pkg/utils"
+)
+
+// Column users can be updated by mask, nil value writes null expression
+type updateColumn struct {
+	name  string
+	null  string
+	value func(user *models.User) interface{}
+}
+
+// Columns users can be updated by, keyed by api field name
+var updateColumns = map[string]updateColumn{
+	"first_name": {name: "first_name", null: "NULL", value: func(user *models.User) interface{} {
+		return user.FirstName
+	}},
+	"last_name": {name: "last_name", null: "NULL", value: func(user *models.User) interface{} {
+		return user.LastName
+	}},
+	"email": {name: "email", null: "NULL", value: func(user *models.User) interface{} {
+		return user.Email
+	}},
+	"about": {name: "about", null: "NULL", value: func(user *models.User) interface{} {
+		return nullString(user.About)
+	}},
+	"phone_number": {name: "phone_number", null: "NULL", value: func(user *models.User) interface{} {
+		return nullString(user.PhoneNumber)
+	}},
+	"address": {name: "address", null: "NULL", value: func(user *models.User) interface{} {
+		return nullString(user.Address)
+	}},
+	"city": {name: "city", null: "NULL", value: func(user *models.User) interface{} {
+		return nullString(user.City)
+	}},
+	"country": {name: "country", null: "NULL", value: func(user *models.User) interface{} {
+		return nullString(user.Country)
+	}},
+	// gender is not nullable, clearing it restores column default
+	"gender": {name: "gender", null: "DEFAULT", value: func(user *models.User) interface{} {
+		return nullString(user.Gender)
+	}},
+	"postcode": {name: "postcode", null: "NULL", value: func(user *models.User) interface{} {
+		if user.Postcode == nil {
+			return nil
+		}
+		return *user.Postcode
+	}},
+	"birthday": {name: "birthday", null: "NULL", value: func(user *models.User) interface{} {
+		return nullString(utils.ParseTimeFormat(user.Birthday))
+	}},
+}
+
+// Update query setting exactly the given fields, changing email resets its verification
+func updateUserSQL(user *models.User, fields []string) (string, []interface{}, error) {
+	set := make([]string, 0, len(fields)+3)
+	args := make([]interface{}, 0, len(fields)+1)
+	seen := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		column, ok := updateColumns[field]
+		if !ok {
+			return "", nil, errors.Wrapf(grpc_errors.ErrInvalidMask, "unknown field %q", field)
+		}
+		if seen[field] {
+			continue
+		}
+		seen[field] = true
+
+		value := column.value(user)
+		if value == nil {
+			set = append(set, column.name+" = "+column.null)
+			continue
+		}
+		args = append(args, value)
+		placeholder := fmt.Sprintf("$%d", len(args))
+		set = append(set, column.name+" = "+placeholder)
+		if column.name == "email" {
+			set = append(set, "email_verified = CASE WHEN "+placeholder+" <> email THEN false ELSE email_verified END")
+		}
+	}
+	if len(set) == 0 {
+		return "", nil, errors.Wrap(grpc_errors.ErrInvalidMask, "no fields")
+	}
+	set = append(set, "updated_at = now()", "version = version + 1")
+	args = append(args, user.UserID)
+
+	return fmt.Sprintf(updateUserQuery, strings.Join(set, ", "), len(args)), args, nil
+}
+
+func nullString(s *string) interface{} {
+	if s == nil {
+		return nil
+	}
+	return *s
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/JamesHsu333/go-grpc/internal/models"
+	"github.com/JamesHsu333/go-grpc/pkg/grpc_errors"
+)
+
+func TestUpdateUserSQL(t *testing.T) {
+	userID := uuid.MustParse("6f1c1a52-1f5e-4a57-9bd1-7d3f0a0c6a11")
+	about := "about"
+	postcode := 12345
+	birthday := time.Date(1990, time.March, 4, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		user      *models.User
+		fields    []string
+		wantQuery string
+		wantArgs  []interface{}
+		wantErr   error
+	}{
+		{
+			name:      "single field",
+			user:      &models.User{UserID: userID, FirstName: "Alex"},
+			fields:    []string{"first_name"},
+			wantQuery: "UPDATE users SET first_name = $1, updated_at = now(), version = version + 1 WHERE user_id = $2 AND deleted_at IS NULL RETURNING *",
+			wantArgs:  []interface{}{"Alex", userID},
+		},
+		{
+			name:   "email resets verification",
+			user:   &models.User{UserID: userID, Email: "alex@example.com"},
+			fields: []string{"email"},
+			wantQuery: "UPDATE users SET email = $1, email_verified = CASE WHEN $1 <> email THEN false ELSE email_verified END, " +
+				"updated_at = now(), version = version + 1 WHERE user_id = $2 AND deleted_at IS NULL RETURNING *",
+			wantArgs: []interface{}{"alex@example.com", userID},
+		},
+		{
+			name:      "nil values write null expressions",
+			user:      &models.User{UserID: userID},
+			fields:    []string{"about", "gender", "postcode"},
+			wantQuery: "UPDATE users SET about = NULL, gender = DEFAULT, postcode = NULL, updated_at = now(), version = version + 1 WHERE user_id = $1 AND deleted_at IS NULL RETURNING *",
+			wantArgs:  []interface{}{userID},
+		},
+		{
+			name:      "values and duplicate fields",
+			user:      &models.User{UserID: userID, About: &about, Postcode: &postcode, Birthday: &birthday},
+			fields:    []string{"about", "postcode", "about", "birthday"},
+			wantQuery: "UPDATE users SET about = $1, postcode = $2, birthday = $3, updated_at = now(), version = version + 1 WHERE user_id = $4 AND deleted_at IS NULL RETURNING *",
+			wantArgs:  []interface{}{"about", 12345, "1990-03-04", userID},
+		},
+		{
+			name:    "unknown field",
+			user:    &models.User{UserID: userID},
+			fields:  []string{"password"},
+			wantErr: grpc_errors.ErrInvalidMask,
+		},
+		{
+			name:    "no fields",
+			user:    &models.User{UserID: userID},
+			wantErr: grpc_errors.ErrInvalidMask,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := updateUserSQL(tt.user, tt.fields)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("updateUserSQL() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("updateUserSQL() unexpected error: %v", err)
+			}
+			if query != tt.wantQuery {
+				t.Errorf("updateUserSQL() query = %q, want %q", query, tt.wantQuery)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("updateUserSQL() args = %#v, want %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
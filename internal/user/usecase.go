@@ -3,6 +3,7 @@ package user
 
 import (
 	"context"
+	"time"
 
 	"github.com/JamesHsu333/go-grpc/internal/models"
 	"github.com/JamesHsu333/go-grpc/pkg/utils"
@@ -12,12 +13,34 @@ import (
 // Auth repository interface
 type UseCase interface {
 	Register(ctx context.Context, user *models.User) (*models.User, error)
-	Login(ctx context.Context, email string, password string) (*models.User, error)
-	Update(ctx context.Context, user *models.User) (*models.User, error)
+	ImportUsers(ctx context.Context, rows []*models.ImportRow, dryRun bool) ([]*models.ImportResult, error)
+	Login(ctx context.Context, email string, password string, clientIP string) (*models.User, error)
+	CompleteLogin(ctx context.Context, user *models.User) error
+	UnlockAccount(ctx context.Context, email string, clientIP string) error
+	Logout(ctx context.Context, session *models.Session) error
+	ListAuditEvents(ctx context.Context, filter *models.AuditFilter, pq *utils.PaginationQuery) (*models.AuditEventsList, error)
+	Update(ctx context.Context, user *models.User, fields []string) (*models.User, error)
 	UpdateRole(ctx context.Context, user *models.User) (*models.User, error)
 	Delete(ctx context.Context, userID uuid.UUID) error
+	Restore(ctx context.Context, userID uuid.UUID) (*models.User, error)
+	PurgeDeleted(ctx context.Context, before time.Time) (int, error)
 	GetByID(ctx context.Context, userID uuid.UUID) (*models.User, error)
+	BatchGetUsers(ctx context.Context, userIDs []uuid.UUID) ([]*models.User, error)
 	FindByName(ctx context.Context, name string, pq *utils.PaginationQuery) (*models.UsersList, error)
-	GetUsers(ctx context.Context, pq *utils.PaginationQuery) (*models.UsersList, error)
-	//UploadAvatar(ctx context.Context, userID uuid.UUID, file models.UploadInput) (*models.User, error)
+	SearchUsers(ctx context.Context, query string, pq *utils.PaginationQuery) (*models.UserSearchList, error)
+	GetUsers(ctx context.Context, filter *models.UserFilter, pq *utils.PaginationQuery) (*models.UsersList, error)
+	ExportUsers(ctx context.Context, filter *models.UserFilter, fn func(user *models.User) error) error
+	WatchUsers(ctx context.Context, resumeToken string, types []string, fn func(change *models.UserChange) error) error
+	PurgeChanges(ctx context.Context, before time.Time) (int64, error)
+	UploadAvatar(ctx context.Context, userID uuid.UUID, file models.UploadInput) (*models.User, error)
+	ChangePassword(ctx context.Context, userID uuid.UUID, currentPassword string, newPassword string) error
+	RequestPasswordReset(ctx context.Context, email string) error
+	ConfirmPasswordReset(ctx context.Context, token string, newPassword string) error
+	VerifyEmail(ctx context.Context, token string) (*models.User, error)
+	ResendVerification(ctx context.Context, email string) error
+	EnrollTOTP(ctx context.Context, userID uuid.UUID) (*models.TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, userID uuid.UUID, code string) error
+	DisableTOTP(ctx context.Context, userID uuid.UUID, code string) error
+	CreateMFAChallenge(ctx context.Context, userID uuid.UUID) (string, error)
+	VerifyMFA(ctx context.Context, token string, code string, clientIP string) (*models.User, error)
 }
@@ -2,36 +2,105 @@ package usecase
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"math/rand"
+	"runtime"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/JamesHsu333/go-grpc/config"
 	"github.com/JamesHsu333/go-grpc/internal/models"
+	"github.com/JamesHsu333/go-grpc/internal/session"
 	"github.com/JamesHsu333/go-grpc/internal/user"
 	"github.com/JamesHsu333/go-grpc/pkg/grpc_errors"
 	"github.com/JamesHsu333/go-grpc/pkg/logger"
+	"github.com/JamesHsu333/go-grpc/pkg/metric"
 	"github.com/JamesHsu333/go-grpc/pkg/utils"
 	"github.com/google/uuid"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
-	basePrefix            = "api-user:"
+	// Users are cached in models.UserCacheEntry envelopes since v2, plain users cached before are never read
+	basePrefix            = "api-user:v2:"
+	passwordResetPrefix   = "api-password-reset:"
+	verifyEmailPrefix     = "api-verify-email:"
+	loginAttemptsPrefix   = "api-login-attempts:"
+	loginLockPrefix       = "api-login-lock:"
+	mfaChallengePrefix    = "api-mfa-challenge:"
+	totpStepPrefix        = "api-totp-step:"
 	userByIdCacheDuration = 3600
+	// Not found ids are cached shortly, so lookups of missing users do not all reach database
+	userNotFoundCacheDuration = 60
+	// Fraction cache durations are randomly spread by, so entries cached together do not expire together
+	userCacheJitter = 0.1
+	// Early refresh eagerness of cached users, see models.UserCacheEntry.ShouldRefresh
+	userCacheRefreshBeta = 1.0
+	userCacheName        = "user"
+	maxBatchGetUsers     = 100
+	importBatchSize      = 500
+	watchBatchSize       = 100
+	watchPollInterval    = 5
+	// Seconds a coalesced user load may take, it runs on after callers gave up waiting
+	userLoadTimeout = 5
+	// TOTP period in seconds, codes of one step before and after current one are accepted
+	totpPeriod = 30
 )
 
+// Compared against on login of unknown email, so it takes as long as a wrong password
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
 // Auth UseCase
 type userUC struct {
-	userRepo  user.UserRepository
-	redisRepo user.RedisRepository
-	//fileRepo  user.FileRepository
-	logger logger.Logger
+	userRepo    user.UserRepository
+	auditRepo   user.AuditRepository
+	outboxRepo  user.OutboxRepository
+	changesRepo user.ChangesRepository
+	redisRepo   user.RedisRepository
+	fileRepo    user.FileRepository
+	mailSender  user.MailSender
+	sessUC      session.UCSession
+	cfg         *config.Config
+	metr        metric.Metrics
+	logger      logger.Logger
+	userLoads   singleflight.Group
 }
 
 // Auth UseCase constructor
-func NewUserUC(userRepo user.UserRepository, redisRepo user.RedisRepository, log logger.Logger) user.UseCase {
-	return &userUC{userRepo: userRepo, redisRepo: redisRepo, logger: log}
+func NewUserUC(
+	userRepo user.UserRepository,
+	auditRepo user.AuditRepository,
+	outboxRepo user.OutboxRepository,
+	changesRepo user.ChangesRepository,
+	redisRepo user.RedisRepository,
+	fileRepo user.FileRepository,
+	mailSender user.MailSender,
+	sessUC session.UCSession,
+	cfg *config.Config,
+	metr metric.Metrics,
+	log logger.Logger,
+) user.UseCase {
+	return &userUC{
+		userRepo:    userRepo,
+		auditRepo:   auditRepo,
+		outboxRepo:  outboxRepo,
+		changesRepo: changesRepo,
+		redisRepo:   redisRepo,
+		fileRepo:    fileRepo,
+		mailSender:  mailSender,
+		sessUC:      sessUC,
+		cfg:         cfg,
+		metr:        metr,
+		logger:      log,
+	}
 }
 
 // Create new user
@@ -39,36 +108,219 @@ func (u *userUC) Register(ctx context.Context, user *models.User) (*models.User,
 	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.Register")
 	defer span.Finish()
 
+	if err := user.PrepareCreate(); err != nil {
+		return nil, errors.Wrap(err, "user.PrepareCreate")
+	}
+
 	existsUser, err := u.userRepo.FindByEmail(ctx, user.Email)
 	if existsUser != nil || err == nil {
 		return nil, grpc_errors.ErrEmailExists
 	}
 
-	createdUser, err := u.userRepo.Register(ctx, user)
-	if err != nil {
+	var createdUser *models.User
+	if err = u.userRepo.WithinTransaction(ctx, func(ctx context.Context) error {
+		if createdUser, err = u.userRepo.Register(ctx, user); err != nil {
+			return err
+		}
+		if err = u.auditRepo.Create(ctx, u.newAuditEvent(ctx, models.AuditActionRegister, createdUser.UserID,
+			models.DiffUsers(&models.User{}, createdUser))); err != nil {
+			return err
+		}
+		return u.createEvent(ctx, models.EventUserRegistered, createdUser, nil)
+	}); err != nil {
 		return nil, err
 	}
 	createdUser.SanitizePassword()
 
+	if err = u.sendVerification(ctx, createdUser); err != nil {
+		u.logger.Errorf("userUC.Register.sendVerification: %v", err)
+	}
+
 	return createdUser, nil
 }
 
-// Update existing user
-func (u *userUC) Update(ctx context.Context, user *models.User) (*models.User, error) {
+// Import users in batches, rows are validated and deduplicated by email and every batch is inserted in one transaction.
+// Users without password get a random one and have to reset it, no verification mail is sent. Dry run writes nothing.
+func (u *userUC) ImportUsers(ctx context.Context, rows []*models.ImportRow, dryRun bool) ([]*models.ImportResult, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.ImportUsers")
+	defer span.Finish()
+
+	results := make([]*models.ImportResult, 0, len(rows))
+	seen := make(map[string]int64, len(rows))
+	for start := 0; start < len(rows); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		batchResults, err := u.importBatch(ctx, rows[start:end], seen, dryRun)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, batchResults...)
+	}
+
+	return results, nil
+}
+
+// Import batch of rows, a failed insert rolls back and fails the whole batch.
+// Emails are added to seen only once their batch is committed
+func (u *userUC) importBatch(ctx context.Context, rows []*models.ImportRow, seen map[string]int64, dryRun bool) ([]*models.ImportResult, error) {
+	results := make([]*models.ImportResult, len(rows))
+	valid := make([]int, 0, len(rows))
+	emails := make([]string, 0, len(rows))
+	batchSeen := make(map[string]int64, len(rows))
+	for i, row := range rows {
+		row.User.Email = strings.ToLower(strings.TrimSpace(row.User.Email))
+		results[i] = &models.ImportResult{Line: row.Line, Email: row.User.Email}
+
+		if err := utils.ValidateStruct(ctx, row.User); err != nil {
+			results[i].Err = err
+			continue
+		}
+		line, ok := seen[row.User.Email]
+		if !ok {
+			line, ok = batchSeen[row.User.Email]
+		}
+		if ok {
+			results[i].Err = errors.Wrapf(grpc_errors.ErrEmailExists, "duplicate of line %d", line)
+			continue
+		}
+		batchSeen[row.User.Email] = row.Line
+		valid = append(valid, i)
+		emails = append(emails, row.User.Email)
+	}
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	existingEmails, err := u.userRepo.FindExistingEmails(ctx, emails)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(existingEmails))
+	for _, email := range existingEmails {
+		existing[email] = true
+	}
+
+	toInsert := make([]int, 0, len(valid))
+	for _, i := range valid {
+		if existing[rows[i].User.Email] {
+			results[i].Err = grpc_errors.ErrEmailExists
+			continue
+		}
+		toInsert = append(toInsert, i)
+	}
+	if dryRun {
+		for _, i := range toInsert {
+			seen[rows[i].User.Email] = rows[i].Line
+		}
+		return results, nil
+	}
+
+	for _, i := range toInsert {
+		if rows[i].User.Password == "" {
+			password, err := utils.GenerateRandomToken()
+			if err != nil {
+				return nil, errors.Wrap(err, "utils.GenerateRandomToken")
+			}
+			rows[i].User.Password = password
+		}
+	}
+	u.prepareImportRows(rows, toInsert, results)
+
+	prepared := make([]int, 0, len(toInsert))
+	for _, i := range toInsert {
+		if results[i].Err == nil {
+			prepared = append(prepared, i)
+		}
+	}
+
+	if err = u.userRepo.WithinTransaction(ctx, func(ctx context.Context) error {
+		for _, i := range prepared {
+			createdUser, err := u.userRepo.Register(ctx, rows[i].User)
+			if err != nil {
+				return errors.Wrapf(err, "line %d", rows[i].Line)
+			}
+			if err = u.auditRepo.Create(ctx, u.newAuditEvent(ctx, models.AuditActionImport, createdUser.UserID,
+				models.DiffUsers(&models.User{}, createdUser))); err != nil {
+				return err
+			}
+			if err = u.createEvent(ctx, models.EventUserRegistered, createdUser, nil); err != nil {
+				return err
+			}
+			results[i].UserID = createdUser.UserID
+		}
+		return nil
+	}); err != nil {
+		u.logger.Errorf("userUC.importBatch.WithinTransaction: %v", err)
+		for _, i := range prepared {
+			results[i].UserID = uuid.Nil
+			results[i].Err = errors.Wrap(err, "batch rolled back")
+		}
+		return results, nil
+	}
+
+	for _, i := range prepared {
+		seen[rows[i].User.Email] = rows[i].Line
+	}
+
+	return results, nil
+}
+
+// Prepare rows at given indexes for create on a bounded number of workers, bcrypt hashing dominates import time
+func (u *userUC) prepareImportRows(rows []*models.ImportRow, indexes []int, results []*models.ImportResult) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := rows[i].User.PrepareCreate(); err != nil {
+					results[i].Err = errors.Wrap(err, "user.PrepareCreate")
+				}
+			}
+		}()
+	}
+
+	for _, i := range indexes {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// Update given fields of existing user, non zero user version must match stored version
+func (u *userUC) Update(ctx context.Context, user *models.User, fields []string) (*models.User, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.Update")
 	defer span.Finish()
 
 	user.Email = strings.ToLower(strings.TrimSpace(user.Email))
 	user.Password = strings.TrimSpace(user.Password)
 
-	updatedUser, err := u.userRepo.Update(ctx, user)
-	if err != nil {
+	var updatedUser *models.User
+	if err := u.userRepo.WithinTransaction(ctx, func(ctx context.Context) error {
+		currentUser, err := u.userRepo.GetByIDForUpdate(ctx, user.UserID)
+		if err != nil {
+			return err
+		}
+		if user.Version != 0 && user.Version != currentUser.Version {
+			return &grpc_errors.VersionConflictError{Expected: user.Version, Current: currentUser.Version}
+		}
+		if updatedUser, err = u.userRepo.Update(ctx, user, fields); err != nil {
+			return err
+		}
+		changes := models.DiffUsers(currentUser, updatedUser)
+		if err = u.auditRepo.Create(ctx, u.newAuditEvent(ctx, models.AuditActionUpdate, user.UserID, changes)); err != nil {
+			return err
+		}
+		return u.createEvent(ctx, models.EventUserUpdated, updatedUser, changes)
+	}); err != nil {
 		return nil, err
 	}
 
-	updatedUser.SanitizePassword()
-
-	if err = u.redisRepo.DeleteUserCtx(ctx, u.generateUserKey(user.UserID.String())); err != nil {
+	if err := u.redisRepo.DeleteUserCtx(ctx, u.generateUserKey(user.UserID.String())); err != nil {
 		u.logger.Errorf("userUC.Update.DeleteUserCtx: %s", err)
 	}
 
@@ -77,15 +329,27 @@ func (u *userUC) Update(ctx context.Context, user *models.User) (*models.User, e
 	return updatedUser, nil
 }
 
-// Delete new user
+// Soft delete user, revokes all user sessions and cached user
 func (u *userUC) Delete(ctx context.Context, userID uuid.UUID) error {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.Delete")
 	defer span.Finish()
 
-	if err := u.userRepo.Delete(ctx, userID); err != nil {
+	if err := u.userRepo.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := u.userRepo.Delete(ctx, userID); err != nil {
+			return err
+		}
+		if err := u.auditRepo.Create(ctx, u.newAuditEvent(ctx, models.AuditActionDelete, userID, nil)); err != nil {
+			return err
+		}
+		return u.createEvent(ctx, models.EventUserDeleted, &models.User{UserID: userID}, nil)
+	}); err != nil {
 		return err
 	}
 
+	if err := u.sessUC.DeleteByUserID(ctx, userID); err != nil {
+		return errors.Wrap(err, "sessUC.DeleteByUserID")
+	}
+
 	if err := u.redisRepo.DeleteUserCtx(ctx, u.generateUserKey(userID.String())); err != nil {
 		u.logger.Errorf("userUC.Delete.DeleteUserCtx: %s", err)
 	}
@@ -93,32 +357,222 @@ func (u *userUC) Delete(ctx context.Context, userID uuid.UUID) error {
 	return nil
 }
 
-// Get user by id
+// Restore soft deleted user
+func (u *userUC) Restore(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.Restore")
+	defer span.Finish()
+
+	var restoredUser *models.User
+	if err := u.userRepo.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if restoredUser, err = u.userRepo.Restore(ctx, userID); err != nil {
+			return err
+		}
+		if err = u.auditRepo.Create(ctx, u.newAuditEvent(ctx, models.AuditActionRestore, userID, nil)); err != nil {
+			return err
+		}
+		return u.createEvent(ctx, models.EventUserRestored, restoredUser, nil)
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := u.redisRepo.DeleteUserCtx(ctx, u.generateUserKey(userID.String())); err != nil {
+		u.logger.Errorf("userUC.Restore.DeleteUserCtx: %s", err)
+	}
+
+	restoredUser.SanitizePassword()
+
+	return restoredUser, nil
+}
+
+// Hard delete users soft deleted before given time and remove their avatars
+func (u *userUC) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.PurgeDeleted")
+	defer span.Finish()
+
+	avatars, err := u.userRepo.PurgeDeleted(ctx, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "userRepo.PurgeDeleted")
+	}
+
+	for _, avatar := range avatars {
+		if avatar == "" {
+			continue
+		}
+		if err := u.fileRepo.RemoveObject(ctx, avatar); err != nil {
+			u.logger.Errorf("userUC.PurgeDeleted.RemoveObject: %s", err)
+		}
+	}
+
+	return len(avatars), nil
+}
+
+// Get user by id, concurrent cache misses of the same user are coalesced into one database query
+// and cached user is refreshed early by one of its callers before it expires
 func (u *userUC) GetByID(ctx context.Context, userID uuid.UUID) (*models.User, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.GetByID")
 	defer span.Finish()
 
-	cachedUser, err := u.redisRepo.GetByIDCtx(ctx, u.generateUserKey(userID.String()))
-
+	entry, err := u.redisRepo.GetUserEntryCtx(ctx, u.generateUserKey(userID.String()))
 	if err != nil {
-		u.logger.Errorf("userUC.redisRepo.GetByIDCtx: %v", err)
+		u.logger.Errorf("userUC.redisRepo.GetUserEntryCtx: %v", err)
+	}
+
+	if entry != nil {
+		if entry.User == nil {
+			u.metr.IncCacheRequests(userCacheName, "negative_hit")
+			return nil, errors.Wrap(grpc_errors.ErrNotFound, "userUC.GetByID")
+		}
+		if !entry.ShouldRefresh(time.Now(), userCacheRefreshBeta) {
+			u.metr.IncCacheRequests(userCacheName, "hit")
+			return entry.User, nil
+		}
+
+		u.metr.IncCacheRequests(userCacheName, "refresh")
+		user, err := u.loadUser(ctx, userID)
+		if err != nil {
+			u.logger.Errorf("userUC.GetByID.loadUser: %v", err)
+			return entry.User, nil
+		}
+		return user, nil
+	}
+
+	u.metr.IncCacheRequests(userCacheName, "miss")
+	return u.loadUser(ctx, userID)
+}
+
+// Load user from database and cache it, not found user is cached as missing. Concurrent loads of
+// the same user share the query of the first one, every caller gets its own copy of user.
+// Shared query is not bound to context of any caller, each caller stops waiting when its context is done
+func (u *userUC) loadUser(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	key := u.generateUserKey(userID.String())
+
+	loaded := false
+	results := u.userLoads.DoChan(key, func() (interface{}, error) {
+		loaded = true
+		start := time.Now()
+
+		ctx, cancel := context.WithTimeout(opentracing.ContextWithSpan(context.Background(), opentracing.SpanFromContext(ctx)),
+			userLoadTimeout*time.Second)
+		defer cancel()
+
+		user, err := u.userRepo.GetByID(ctx, userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				entry := &models.UserCacheEntry{ExpiresAt: u.userCacheExpiry(userNotFoundCacheDuration)}
+				if err := u.redisRepo.SetUserEntryCtx(ctx, key, entry); err != nil {
+					u.logger.Errorf("userUC.redisRepo.SetUserEntryCtx: %v", err)
+				}
+			}
+			return nil, err
+		}
+		user.SanitizePassword()
+
+		entry := &models.UserCacheEntry{User: user, Delta: time.Since(start), ExpiresAt: u.userCacheExpiry(userByIdCacheDuration)}
+		if err = u.redisRepo.SetUserEntryCtx(ctx, key, entry); err != nil {
+			u.logger.Errorf("userUC.redisRepo.SetUserEntryCtx: %v", err)
+		}
+
+		return user, nil
+	})
+
+	var result singleflight.Result
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result = <-results:
+	}
+
+	if !loaded {
+		u.metr.IncCacheRequests(userCacheName, "coalesced")
 	}
-	if cachedUser != nil {
-		return cachedUser, nil
+	if result.Err != nil {
+		return nil, result.Err
 	}
 
-	user, err := u.userRepo.GetByID(ctx, userID)
+	user := *result.Val.(*models.User)
+	return &user, nil
+}
+
+// Cache expiry after duration in seconds, spread randomly by jitter fraction
+func (u *userUC) userCacheExpiry(seconds int) time.Time {
+	duration := time.Duration(seconds) * time.Second
+	jitter := time.Duration((rand.Float64()*2 - 1) * userCacheJitter * float64(duration))
+	return time.Now().Add(duration + jitter)
+}
+
+// Get users by ids in request order, cache misses are loaded with one query, users not found are nil
+func (u *userUC) BatchGetUsers(ctx context.Context, userIDs []uuid.UUID) ([]*models.User, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.BatchGetUsers")
+	defer span.Finish()
+
+	if len(userIDs) > maxBatchGetUsers {
+		return nil, errors.Wrapf(grpc_errors.ErrBatchTooLarge, "%d ids, max %d", len(userIDs), maxBatchGetUsers)
+	}
+
+	keys := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		keys = append(keys, u.generateUserKey(userID.String()))
+	}
+
+	cachedEntries, err := u.redisRepo.GetUserEntriesCtx(ctx, keys)
 	if err != nil {
-		return nil, err
+		u.logger.Errorf("userUC.redisRepo.GetUserEntriesCtx: %v", err)
+		cachedEntries = make([]*models.UserCacheEntry, len(userIDs))
+	}
+
+	foundUsers := make(map[uuid.UUID]*models.User, len(userIDs))
+	missingIDs := make([]uuid.UUID, 0, len(userIDs))
+	for i, userID := range userIDs {
+		if _, ok := foundUsers[userID]; ok {
+			continue
+		}
+		if entry := cachedEntries[i]; entry != nil {
+			foundUsers[userID] = entry.User
+			if entry.User == nil {
+				u.metr.IncCacheRequests(userCacheName, "negative_hit")
+			} else {
+				u.metr.IncCacheRequests(userCacheName, "hit")
+			}
+			continue
+		}
+		u.metr.IncCacheRequests(userCacheName, "miss")
+		foundUsers[userID] = nil
+		missingIDs = append(missingIDs, userID)
 	}
 
-	if err = u.redisRepo.SetUserCtx(ctx, u.generateUserKey(userID.String()), userByIdCacheDuration, user); err != nil {
-		u.logger.Errorf("userUC.redisRepo.SetUserCtx: %v", err)
+	if len(missingIDs) > 0 {
+		start := time.Now()
+		users, err := u.userRepo.GetByIDs(ctx, missingIDs)
+		if err != nil {
+			return nil, err
+		}
+		delta := time.Since(start)
+
+		for _, user := range users {
+			user.SanitizePassword()
+			foundUsers[user.UserID] = user
+		}
+
+		entriesToCache := make(map[string]*models.UserCacheEntry, len(missingIDs))
+		for _, userID := range missingIDs {
+			entry := &models.UserCacheEntry{ExpiresAt: u.userCacheExpiry(userNotFoundCacheDuration)}
+			if user := foundUsers[userID]; user != nil {
+				entry = &models.UserCacheEntry{User: user, Delta: delta, ExpiresAt: u.userCacheExpiry(userByIdCacheDuration)}
+			}
+			entriesToCache[u.generateUserKey(userID.String())] = entry
+		}
+		if err = u.redisRepo.SetUserEntriesCtx(ctx, entriesToCache); err != nil {
+			u.logger.Errorf("userUC.redisRepo.SetUserEntriesCtx: %v", err)
+		}
 	}
 
-	user.SanitizePassword()
+	users := make([]*models.User, 0, len(userIDs))
+	for _, userID := range userIDs {
+		users = append(users, foundUsers[userID])
+	}
 
-	return user, nil
+	return users, nil
 }
 
 // Find users by name
@@ -129,26 +583,175 @@ func (u *userUC) FindByName(ctx context.Context, name string, pq *utils.Paginati
 	return u.userRepo.FindByName(ctx, name, pq)
 }
 
-// Get users with pagination
-func (u *userUC) GetUsers(ctx context.Context, pq *utils.PaginationQuery) (*models.UsersList, error) {
+// Search users ranked by relevance
+func (u *userUC) SearchUsers(ctx context.Context, query string, pq *utils.PaginationQuery) (*models.UserSearchList, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.SearchUsers")
+	defer span.Finish()
+
+	return u.userRepo.SearchUsers(ctx, strings.TrimSpace(query), pq)
+}
+
+// Get users matching filter with pagination
+func (u *userUC) GetUsers(ctx context.Context, filter *models.UserFilter, pq *utils.PaginationQuery) (*models.UsersList, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.GetUsers")
 	defer span.Finish()
 
-	return u.userRepo.GetUsers(ctx, pq)
+	return u.userRepo.GetUsers(ctx, filter, pq)
+}
+
+// Export users matching filter from a consistent snapshot, password hashes are never exported
+func (u *userUC) ExportUsers(ctx context.Context, filter *models.UserFilter, fn func(user *models.User) error) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.ExportUsers")
+	defer span.Finish()
+
+	return u.userRepo.ExportUsers(ctx, filter, func(user *models.User) error {
+		user.SanitizePassword()
+		return fn(user)
+	})
+}
+
+// Watch user changes after resume token, or from now without it, fn is called for every change in commit order
+// until context is done. Watcher is woken by change notifications and polls in case a notification was lost.
+// While a long transaction holds back snapshot xmin no changes are delivered, see userChangesRepo.ListChanges
+func (u *userUC) WatchUsers(ctx context.Context, resumeToken string, types []string, fn func(change *models.UserChange) error) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.WatchUsers")
+	defer span.Finish()
+
+	for _, changeType := range types {
+		if !models.IsUserChangeType(changeType) {
+			return errors.Wrap(grpc_errors.ErrInvalidChange, changeType)
+		}
+	}
+
+	wake, unsubscribe := u.changesRepo.Subscribe()
+	defer unsubscribe()
+
+	token := resumeToken
+	if token != "" {
+		if err := u.changesRepo.CheckToken(token); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		if token, err = u.changesRepo.CurrentToken(ctx); err != nil {
+			return err
+		}
+	}
+
+	pollInterval := u.cfg.Watch.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = watchPollInterval
+	}
+	poll := time.NewTicker(time.Duration(pollInterval) * time.Second)
+	defer poll.Stop()
+
+	for {
+		changes, err := u.changesRepo.ListChanges(ctx, token, types, watchBatchSize)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if err = u.loadChangedUsers(ctx, changes); err != nil {
+			return err
+		}
+
+		for _, change := range changes {
+			if err = fn(change); err != nil {
+				return err
+			}
+			token = change.ResumeToken
+		}
+		if len(changes) == watchBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-wake:
+		case <-poll.C:
+		}
+	}
+}
+
+// Set current state of created and updated users, users deleted meanwhile are left unset
+func (u *userUC) loadChangedUsers(ctx context.Context, changes []*models.UserChange) error {
+	userIDs := make([]uuid.UUID, 0, len(changes))
+	for _, change := range changes {
+		if change.Type != models.UserChangeDeleted {
+			userIDs = append(userIDs, change.UserID)
+		}
+	}
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	users, err := u.userRepo.GetByIDs(ctx, userIDs)
+	if err != nil {
+		return err
+	}
+
+	usersByID := make(map[uuid.UUID]*models.User, len(users))
+	for _, user := range users {
+		usersByID[user.UserID] = user
+	}
+	for _, change := range changes {
+		if change.Type != models.UserChangeDeleted {
+			change.User = usersByID[change.UserID]
+		}
+	}
+
+	return nil
+}
+
+// Delete recorded user changes older than given time
+func (u *userUC) PurgeChanges(ctx context.Context, before time.Time) (int64, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.PurgeChanges")
+	defer span.Finish()
+
+	deleted, err := u.changesRepo.DeleteChangesBefore(ctx, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "changesRepo.DeleteChangesBefore")
+	}
+
+	return deleted, nil
 }
 
 // Login user, returns user model with jwt token
-func (u *userUC) Login(ctx context.Context, email string, password string) (*models.User, error) {
+func (u *userUC) Login(ctx context.Context, email string, password string, clientIP string) (*models.User, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.Login")
 	defer span.Finish()
 
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	if err := u.checkLoginLock(ctx, email, clientIP); err != nil {
+		return nil, err
+	}
+
+	if err := u.delayLogin(ctx, email); err != nil {
+		return nil, err
+	}
+
 	foundUser, err := u.userRepo.FindByEmail(ctx, email)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+			return nil, u.failLogin(ctx, email, clientIP, grpc_errors.ErrInvalidLogin)
+		}
 		return nil, errors.Wrap(err, "userRepo.FindByEmail")
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(foundUser.Password), []byte(password)); err != nil {
-		return nil, errors.Wrap(err, "user.ComparePasswords")
+		if err := u.auditRepo.Create(ctx, u.newAuditEvent(ctx, models.AuditActionLoginFailed, foundUser.UserID, nil)); err != nil {
+			u.logger.Errorf("userUC.Login.auditRepo.Create: %s", err)
+		}
+		return nil, u.failLogin(ctx, email, clientIP, grpc_errors.ErrInvalidLogin)
+	}
+
+	if u.cfg.Verify.RequireVerifiedEmail && !foundUser.EmailVerified {
+		return nil, grpc_errors.ErrEmailNotVerified
 	}
 
 	foundUser.SanitizePassword()
@@ -156,72 +759,667 @@ func (u *userUC) Login(ctx context.Context, email string, password string) (*mod
 	return foundUser, nil
 }
 
-// Update user role
-func (u *userUC) UpdateRole(ctx context.Context, user *models.User) (*models.User, error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.UpdateRole")
+// Complete login once session of user is created, resets failed login attempts of email and audits login
+func (u *userUC) CompleteLogin(ctx context.Context, user *models.User) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.CompleteLogin")
 	defer span.Finish()
 
-	user.Email = strings.ToLower(strings.TrimSpace(user.Email))
-	user.Password = strings.TrimSpace(user.Password)
+	if err := u.auditRepo.Create(ctx, u.newAuditEvent(ctx, models.AuditActionLogin, user.UserID, nil)); err != nil {
+		u.logger.Errorf("userUC.CompleteLogin.auditRepo.Create: %s", err)
+	}
 
-	updatedUser, err := u.userRepo.UpdateRole(ctx, user)
-	if err != nil {
-		return nil, err
+	if err := u.redisRepo.DeleteKeysCtx(ctx, u.generateLoginAttemptsKey("email", strings.ToLower(user.Email))); err != nil {
+		return errors.Wrap(err, "redisRepo.DeleteKeysCtx")
 	}
 
-	updatedUser.SanitizePassword()
+	return nil
+}
 
-	if err = u.redisRepo.DeleteUserCtx(ctx, u.generateUserKey(user.UserID.String())); err != nil {
-		u.logger.Errorf("userUC.UpdateRole.DeleteUserCtx: %s", err)
+// Logout user by deleting session
+func (u *userUC) Logout(ctx context.Context, session *models.Session) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.Logout")
+	defer span.Finish()
+
+	if err := u.sessUC.DeleteByID(ctx, session.SessionID); err != nil {
+		return errors.Wrap(err, "sessUC.DeleteByID")
 	}
 
-	updatedUser.SanitizePassword()
+	if err := u.auditRepo.Create(ctx, u.newAuditEvent(ctx, models.AuditActionLogout, session.UserID, nil)); err != nil {
+		u.logger.Errorf("userUC.Logout.auditRepo.Create: %s", err)
+	}
 
-	return updatedUser, nil
+	return nil
 }
 
-/*
-// Upload user avatar
-func (u *userUC) UploadAvatar(ctx context.Context, userID uuid.UUID, file models.UploadInput) (*models.User, error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.UploadAvatar")
+// List audit events, newest first
+func (u *userUC) ListAuditEvents(ctx context.Context, filter *models.AuditFilter, pq *utils.PaginationQuery) (*models.AuditEventsList, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.ListAuditEvents")
 	defer span.Finish()
 
-	filepath, err := u.fileRepo.PutObject(ctx, file)
-	if err != nil {
-		return nil, httpErrors.NewInternalServerError(errors.Wrap(err, "userUC.UploadAvatar.PutObject"))
+	return u.auditRepo.List(ctx, filter, pq)
+}
+
+// Remove login lock and failed attempts of email and client ip, empty values are skipped
+func (u *userUC) UnlockAccount(ctx context.Context, email string, clientIP string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.UnlockAccount")
+	defer span.Finish()
+
+	keys := make([]string, 0, 4)
+	if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+		keys = append(keys, u.generateLoginLockKey("email", email), u.generateLoginAttemptsKey("email", email))
+	}
+	if clientIP != "" {
+		keys = append(keys, u.generateLoginLockKey("ip", clientIP), u.generateLoginAttemptsKey("ip", clientIP))
+	}
+	if len(keys) == 0 {
+		return nil
 	}
 
-	user, err := u.userRepo.GetByID(ctx, userID)
-	if err != nil {
-		return nil, err
+	if err := u.redisRepo.DeleteKeysCtx(ctx, keys...); err != nil {
+		return errors.Wrap(err, "redisRepo.DeleteKeysCtx")
 	}
 
-	if user.Avatar != nil {
-		err := u.fileRepo.RemoveObject(ctx, *user.Avatar)
-		if err != nil {
-			return nil, err
-		}
+	u.logger.Infof("userUC.UnlockAccount: unlocked email %q ip %q", email, clientIP)
+
+	return nil
+}
+
+// Start TOTP enrollment, returns secret, otpauth url and recovery codes which are shown only once
+func (u *userUC) EnrollTOTP(ctx context.Context, userID uuid.UUID) (*models.TOTPEnrollment, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.EnrollTOTP")
+	defer span.Finish()
+
+	foundUser, err := u.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "userRepo.GetByID")
 	}
 
-	updatedUser, err := u.userRepo.Update(ctx, &models.User{
-		UserID: userID,
-		Avatar: filepath,
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      u.cfg.MFA.Issuer,
+		AccountName: foundUser.Email,
 	})
-
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "totp.Generate")
 	}
 
-	updatedUser.SanitizePassword()
+	recoveryCodes := make([]string, 0, u.cfg.MFA.RecoveryCodes)
+	recoveryCodeHashes := make([]string, 0, u.cfg.MFA.RecoveryCodes)
+	for i := 0; i < u.cfg.MFA.RecoveryCodes; i++ {
+		code, err := utils.GenerateRecoveryCode()
+		if err != nil {
+			return nil, errors.Wrap(err, "utils.GenerateRecoveryCode")
+		}
+		recoveryCodes = append(recoveryCodes, code)
+		recoveryCodeHashes = append(recoveryCodeHashes, utils.HashToken(utils.NormalizeRecoveryCode(code)))
+	}
 
-	if err = u.redisRepo.DeleteUserCtx(ctx, u.GenerateUserKey(userID.String())); err != nil {
-		u.logger.Errorf("userUC.UploadAvatar.DeleteUserCtx: %s", err)
+	if err = u.userRepo.CreateTOTP(ctx, userID, key.Secret(), recoveryCodeHashes); err != nil {
+		return nil, errors.Wrap(err, "userRepo.CreateTOTP")
 	}
 
-	updatedUser.SanitizePassword()
+	return &models.TOTPEnrollment{
+		Secret:        key.Secret(),
+		URL:           key.URL(),
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+// Confirm TOTP enrollment with current code, enables MFA for login
+func (u *userUC) ConfirmTOTP(ctx context.Context, userID uuid.UUID, code string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.ConfirmTOTP")
+	defer span.Finish()
+
+	userTOTP, err := u.userRepo.GetTOTP(ctx, userID)
+	if err != nil {
+		return errors.Wrap(err, "userRepo.GetTOTP")
+	}
+	if userTOTP.Enabled {
+		return grpc_errors.ErrMFAEnabled
+	}
+
+	valid, err := u.useTOTPCode(ctx, userTOTP, code)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return grpc_errors.ErrInvalidMFACode
+	}
+
+	if err = u.userRepo.EnableTOTP(ctx, userID); err != nil {
+		return errors.Wrap(err, "userRepo.EnableTOTP")
+	}
+
+	return nil
+}
+
+// Disable TOTP, requires current code or unused recovery code
+func (u *userUC) DisableTOTP(ctx context.Context, userID uuid.UUID, code string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.DisableTOTP")
+	defer span.Finish()
+
+	userTOTP, err := u.getEnabledTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err = u.validateMFACode(ctx, userTOTP, code); err != nil {
+		return err
+	}
+
+	if err = u.userRepo.DeleteTOTP(ctx, userID); err != nil {
+		return errors.Wrap(err, "userRepo.DeleteTOTP")
+	}
+
+	return nil
+}
+
+// Create single use MFA challenge token for user with enabled TOTP, returns empty token otherwise
+func (u *userUC) CreateMFAChallenge(ctx context.Context, userID uuid.UUID) (string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.CreateMFAChallenge")
+	defer span.Finish()
+
+	if _, err := u.getEnabledTOTP(ctx, userID); err != nil {
+		if errors.Is(err, grpc_errors.ErrMFANotEnabled) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	token, err := utils.GenerateRandomToken()
+	if err != nil {
+		return "", errors.Wrap(err, "utils.GenerateRandomToken")
+	}
+
+	if err = u.redisRepo.SetTokenCtx(ctx, u.generateMFAChallengeKey(token), u.cfg.MFA.ChallengeExpire, userID); err != nil {
+		return "", errors.Wrap(err, "redisRepo.SetTokenCtx")
+	}
+
+	return token, nil
+}
+
+// Complete MFA challenge with TOTP or recovery code, challenge can be used only once.
+// Invalid codes count as failed login attempts of user email and client ip
+func (u *userUC) VerifyMFA(ctx context.Context, token string, code string, clientIP string) (*models.User, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.VerifyMFA")
+	defer span.Finish()
+
+	userID, err := u.redisRepo.PopTokenCtx(ctx, u.generateMFAChallengeKey(token))
+	if err != nil {
+		return nil, errors.Wrap(err, "redisRepo.PopTokenCtx")
+	}
+
+	foundUser, err := u.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "userRepo.GetByID")
+	}
+
+	email := strings.ToLower(foundUser.Email)
+	if err = u.checkLoginLock(ctx, email, clientIP); err != nil {
+		return nil, err
+	}
+
+	userTOTP, err := u.getEnabledTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = u.validateMFACode(ctx, userTOTP, code); err != nil {
+		if errors.Is(err, grpc_errors.ErrInvalidMFACode) {
+			if err := u.auditRepo.Create(ctx, u.newAuditEvent(ctx, models.AuditActionLoginFailed, userID, nil)); err != nil {
+				u.logger.Errorf("userUC.VerifyMFA.auditRepo.Create: %s", err)
+			}
+			return nil, u.failLogin(ctx, email, clientIP, err)
+		}
+		return nil, err
+	}
+
+	foundUser.SanitizePassword()
+
+	return foundUser, nil
+}
+
+// Update user role, non zero user version must match stored version
+func (u *userUC) UpdateRole(ctx context.Context, user *models.User) (*models.User, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.UpdateRole")
+	defer span.Finish()
+
+	user.Email = strings.ToLower(strings.TrimSpace(user.Email))
+	user.Password = strings.TrimSpace(user.Password)
+
+	var updatedUser *models.User
+	if err := u.userRepo.WithinTransaction(ctx, func(ctx context.Context) error {
+		currentUser, err := u.userRepo.GetByIDForUpdate(ctx, user.UserID)
+		if err != nil {
+			return err
+		}
+		if user.Version != 0 && user.Version != currentUser.Version {
+			return &grpc_errors.VersionConflictError{Expected: user.Version, Current: currentUser.Version}
+		}
+		if updatedUser, err = u.userRepo.UpdateRole(ctx, user); err != nil {
+			return err
+		}
+		changes := models.DiffUsers(currentUser, updatedUser)
+		if err = u.auditRepo.Create(ctx, u.newAuditEvent(ctx, models.AuditActionUpdateRole, user.UserID, changes)); err != nil {
+			return err
+		}
+		return u.createEvent(ctx, models.EventUserRoleChanged, updatedUser, changes)
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := u.redisRepo.DeleteUserCtx(ctx, u.generateUserKey(user.UserID.String())); err != nil {
+		u.logger.Errorf("userUC.UpdateRole.DeleteUserCtx: %s", err)
+	}
+
+	updatedUser.SanitizePassword()
+
+	return updatedUser, nil
+}
+
+// Upload user avatar, replaces and removes previous one
+func (u *userUC) UploadAvatar(ctx context.Context, userID uuid.UUID, file models.UploadInput) (*models.User, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.UploadAvatar")
+	defer span.Finish()
+
+	user, err := u.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	objectName, err := u.fileRepo.PutObject(ctx, file)
+	if err != nil {
+		return nil, errors.Wrap(err, "userUC.UploadAvatar.PutObject")
+	}
+
+	var updatedUser *models.User
+	if err = u.userRepo.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if updatedUser, err = u.userRepo.UpdateAvatar(ctx, userID, objectName); err != nil {
+			return err
+		}
+		return u.createEvent(ctx, models.EventUserUpdated, updatedUser, models.DiffUsers(user, updatedUser))
+	}); err != nil {
+		if err := u.fileRepo.RemoveObject(ctx, *objectName); err != nil {
+			u.logger.Errorf("userUC.UploadAvatar.RemoveObject: %s", err)
+		}
+		return nil, err
+	}
+
+	if user.Avatar != nil && *user.Avatar != "" {
+		if err := u.fileRepo.RemoveObject(ctx, *user.Avatar); err != nil {
+			u.logger.Errorf("userUC.UploadAvatar.RemoveObject: %s", err)
+		}
+	}
+
+	if err = u.redisRepo.DeleteUserCtx(ctx, u.generateUserKey(userID.String())); err != nil {
+		u.logger.Errorf("userUC.UploadAvatar.DeleteUserCtx: %s", err)
+	}
+
+	updatedUser.SanitizePassword()
+
+	return updatedUser, nil
+}
+
+// Change user password, current password must match, all user sessions are revoked
+func (u *userUC) ChangePassword(ctx context.Context, userID uuid.UUID, currentPassword string, newPassword string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.ChangePassword")
+	defer span.Finish()
+
+	user, err := u.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	foundUser, err := u.userRepo.FindByEmail(ctx, user.Email)
+	if err != nil {
+		return errors.Wrap(err, "userRepo.FindByEmail")
+	}
+
+	if err = foundUser.ComparePasswords(currentPassword); err != nil {
+		return errors.Wrap(grpc_errors.ErrWrongPassword, err.Error())
+	}
+
+	return u.updatePassword(ctx, userID, newPassword)
+}
+
+// Send single use password reset token to user email, unknown emails are silently ignored. Only the latest
+// token of user is valid
+func (u *userUC) RequestPasswordReset(ctx context.Context, email string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.RequestPasswordReset")
+	defer span.Finish()
+
+	foundUser, err := u.userRepo.FindByEmail(ctx, strings.ToLower(strings.TrimSpace(email)))
+	if err != nil {
+		u.logger.Infof("userUC.RequestPasswordReset.FindByEmail: %v", err)
+		return nil
+	}
+
+	token, err := utils.GenerateRandomToken()
+	if err != nil {
+		return errors.Wrap(err, "utils.GenerateRandomToken")
+	}
+
+	if err = u.redisRepo.SetOwnedTokenCtx(ctx, u.generatePasswordResetKey(token), u.generatePasswordResetOwnerKey(foundUser.UserID),
+		u.cfg.Password.ResetTokenExpire, foundUser.UserID,
+	); err != nil {
+		return errors.Wrap(err, "redisRepo.SetOwnedTokenCtx")
+	}
+
+	return u.mailSender.Send(ctx, &models.Mail{
+		To:      foundUser.Email,
+		Subject: "Password reset",
+		Body:    fmt.Sprintf("Use the following token to reset your password: %s", token),
+	})
+}
+
+// Reset user password with token, all user sessions are revoked
+func (u *userUC) ConfirmPasswordReset(ctx context.Context, token string, newPassword string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.ConfirmPasswordReset")
+	defer span.Finish()
+
+	userID, err := u.redisRepo.PopTokenCtx(ctx, u.generatePasswordResetKey(token))
+	if err != nil {
+		return errors.Wrap(err, "redisRepo.PopTokenCtx")
+	}
+
+	return u.updatePassword(ctx, userID, newPassword)
+}
+
+// Verify user email with token sent on register
+func (u *userUC) VerifyEmail(ctx context.Context, token string) (*models.User, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.VerifyEmail")
+	defer span.Finish()
+
+	emailToken, err := u.redisRepo.PopEmailTokenCtx(ctx, u.generateVerifyEmailKey(token))
+	if err != nil {
+		return nil, errors.Wrap(err, "redisRepo.PopEmailTokenCtx")
+	}
+
+	verifiedUser, err := u.userRepo.VerifyEmail(ctx, emailToken.UserID, emailToken.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.Wrap(grpc_errors.ErrInvalidToken, "email changed since token was sent")
+		}
+		return nil, err
+	}
+
+	if err = u.redisRepo.DeleteUserCtx(ctx, u.generateUserKey(emailToken.UserID.String())); err != nil {
+		u.logger.Errorf("userUC.VerifyEmail.DeleteUserCtx: %s", err)
+	}
+
+	verifiedUser.SanitizePassword()
+
+	return verifiedUser, nil
+}
+
+// Send new verification token, unknown and already verified emails are silently ignored
+func (u *userUC) ResendVerification(ctx context.Context, email string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "userUC.ResendVerification")
+	defer span.Finish()
+
+	foundUser, err := u.userRepo.FindByEmail(ctx, strings.ToLower(strings.TrimSpace(email)))
+	if err != nil {
+		u.logger.Infof("userUC.ResendVerification.FindByEmail: %v", err)
+		return nil
+	}
+	if foundUser.EmailVerified {
+		return nil
+	}
+
+	return u.sendVerification(ctx, foundUser)
+}
+
+func (u *userUC) sendVerification(ctx context.Context, user *models.User) error {
+	token, err := utils.GenerateRandomToken()
+	if err != nil {
+		return errors.Wrap(err, "utils.GenerateRandomToken")
+	}
+
+	emailToken := &models.EmailToken{UserID: user.UserID, Email: user.Email}
+	if err = u.redisRepo.SetEmailTokenCtx(ctx, u.generateVerifyEmailKey(token), u.generateVerifyEmailOwnerKey(user.UserID),
+		u.cfg.Verify.TokenExpire, emailToken,
+	); err != nil {
+		return errors.Wrap(err, "redisRepo.SetEmailTokenCtx")
+	}
+
+	return u.mailSender.Send(ctx, &models.Mail{
+		To:      user.Email,
+		Subject: "Email verification",
+		Body:    fmt.Sprintf("Use the following token to verify your email: %s", token),
+	})
+}
+
+func (u *userUC) updatePassword(ctx context.Context, userID uuid.UUID, password string) error {
+	user := &models.User{Password: strings.TrimSpace(password)}
+	if err := user.HashPassword(); err != nil {
+		return errors.Wrap(err, "user.HashPassword")
+	}
+
+	if err := u.userRepo.UpdatePassword(ctx, userID, user.Password); err != nil {
+		return err
+	}
+
+	if err := u.redisRepo.DeleteUserCtx(ctx, u.generateUserKey(userID.String())); err != nil {
+		u.logger.Errorf("userUC.updatePassword.DeleteUserCtx: %s", err)
+	}
+
+	if err := u.redisRepo.DeleteOwnedTokenCtx(ctx, u.generatePasswordResetOwnerKey(userID)); err != nil {
+		return errors.Wrap(err, "redisRepo.DeleteOwnedTokenCtx")
+	}
+
+	if err := u.sessUC.DeleteByUserID(ctx, userID); err != nil {
+		return errors.Wrap(err, "sessUC.DeleteByUserID")
+	}
+
+	return nil
+}
+
+func (u *userUC) generatePasswordResetKey(token string) string {
+	return fmt.Sprintf("%s: %s", passwordResetPrefix, utils.HashToken(token))
+}
+
+func (u *userUC) generatePasswordResetOwnerKey(userID uuid.UUID) string {
+	return fmt.Sprintf("%s: user:%s", passwordResetPrefix, userID)
+}
+
+func (u *userUC) generateVerifyEmailKey(token string) string {
+	return fmt.Sprintf("%s: %s", verifyEmailPrefix, utils.HashToken(token))
+}
+
+func (u *userUC) generateVerifyEmailOwnerKey(userID uuid.UUID) string {
+	return fmt.Sprintf("%s: user:%s", verifyEmailPrefix, userID)
+}
 
-	return updatedUser, nil
-}*/
 func (u *userUC) generateUserKey(userID string) string {
 	return fmt.Sprintf("%s: %s", basePrefix, userID)
 }
+
+func (u *userUC) generateLoginAttemptsKey(kind string, value string) string {
+	return fmt.Sprintf("%s: %s:%s", loginAttemptsPrefix, kind, value)
+}
+
+func (u *userUC) generateLoginLockKey(kind string, value string) string {
+	return fmt.Sprintf("%s: %s:%s", loginLockPrefix, kind, value)
+}
+
+// Return lockout error if email or client ip is locked
+func (u *userUC) checkLoginLock(ctx context.Context, email string, clientIP string) error {
+	keys := []string{u.generateLoginLockKey("email", email)}
+	if clientIP != "" {
+		keys = append(keys, u.generateLoginLockKey("ip", clientIP))
+	}
+
+	for _, key := range keys {
+		ttl, err := u.redisRepo.GetLockTTLCtx(ctx, key)
+		if err != nil {
+			return errors.Wrap(err, "redisRepo.GetLockTTLCtx")
+		}
+		if ttl > 0 {
+			return &grpc_errors.LockoutError{RetryAfter: ttl}
+		}
+	}
+
+	return nil
+}
+
+// Delay login by number of recent failed attempts of email
+func (u *userUC) delayLogin(ctx context.Context, email string) error {
+	attempts, err := u.redisRepo.GetCounterCtx(ctx, u.generateLoginAttemptsKey("email", email))
+	if err != nil {
+		return errors.Wrap(err, "redisRepo.GetCounterCtx")
+	}
+	if attempts == 0 {
+		return nil
+	}
+
+	delay := time.Duration(attempts) * time.Duration(u.cfg.Lockout.DelayStep) * time.Millisecond
+	if maxDelay := time.Duration(u.cfg.Lockout.MaxDelay) * time.Millisecond; delay > maxDelay {
+		delay = maxDelay
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Count failed login attempt and lock email or client ip once threshold is reached,
+// returns lockout error or given login error
+func (u *userUC) failLogin(ctx context.Context, email string, clientIP string, loginErr error) error {
+	if err := u.countFailedLogin(ctx, "email", email, u.cfg.Lockout.MaxEmailAttempts); err != nil {
+		return err
+	}
+	if clientIP != "" {
+		if err := u.countFailedLogin(ctx, "ip", clientIP, u.cfg.Lockout.MaxIPAttempts); err != nil {
+			return err
+		}
+	}
+
+	return loginErr
+}
+
+func (u *userUC) countFailedLogin(ctx context.Context, kind string, value string, maxAttempts int) error {
+	attempts, err := u.redisRepo.IncrCounterCtx(ctx, u.generateLoginAttemptsKey(kind, value), u.cfg.Lockout.AttemptsWindow)
+	if err != nil {
+		return errors.Wrap(err, "redisRepo.IncrCounterCtx")
+	}
+	if maxAttempts <= 0 || attempts < int64(maxAttempts) {
+		return nil
+	}
+
+	if err = u.redisRepo.LockCtx(ctx, u.generateLoginLockKey(kind, value), u.cfg.Lockout.Duration); err != nil {
+		return errors.Wrap(err, "redisRepo.LockCtx")
+	}
+	if err = u.redisRepo.DeleteKeysCtx(ctx, u.generateLoginAttemptsKey(kind, value)); err != nil {
+		u.logger.Errorf("userUC.countFailedLogin.DeleteKeysCtx: %s", err)
+	}
+
+	u.logger.Warnf("userUC.Login: %s %q locked after %d failed attempts", kind, value, attempts)
+	u.metr.IncLockouts(kind)
+
+	return &grpc_errors.LockoutError{RetryAfter: time.Duration(u.cfg.Lockout.Duration) * time.Second}
+}
+
+func (u *userUC) generateMFAChallengeKey(token string) string {
+	return fmt.Sprintf("%s: %s", mfaChallengePrefix, utils.HashToken(token))
+}
+
+func (u *userUC) generateTOTPStepKey(userID uuid.UUID) string {
+	return fmt.Sprintf("%s: %s", totpStepPrefix, userID.String())
+}
+
+func (u *userUC) getEnabledTOTP(ctx context.Context, userID uuid.UUID) (*models.TOTP, error) {
+	userTOTP, err := u.userRepo.GetTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, grpc_errors.ErrMFANotEnabled
+		}
+		return nil, errors.Wrap(err, "userRepo.GetTOTP")
+	}
+	if !userTOTP.Enabled {
+		return nil, grpc_errors.ErrMFANotEnabled
+	}
+	return userTOTP, nil
+}
+
+// Accept current TOTP code, otherwise try to consume recovery code
+func (u *userUC) validateMFACode(ctx context.Context, userTOTP *models.TOTP, code string) error {
+	valid, err := u.useTOTPCode(ctx, userTOTP, code)
+	if err != nil {
+		return err
+	}
+	if valid {
+		return nil
+	}
+
+	if err := u.userRepo.UseRecoveryCode(ctx, userTOTP.UserID, utils.HashToken(utils.NormalizeRecoveryCode(code))); err != nil {
+		return err
+	}
+
+	u.logger.Infof("userUC.validateMFACode: user %s used recovery code", userTOTP.UserID)
+
+	return nil
+}
+
+// Validate TOTP code and mark its time step as used, codes of already used or earlier steps are rejected
+func (u *userUC) useTOTPCode(ctx context.Context, userTOTP *models.TOTP, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	now := time.Now().UTC()
+
+	for _, skew := range []int64{0, -1, 1} {
+		at := now.Add(time.Duration(skew*totpPeriod) * time.Second)
+		valid, err := totp.ValidateCustom(code, userTOTP.Secret, at, totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil || !valid {
+			continue
+		}
+
+		// Key expires once stored step is outside of accepted skew
+		unused, err := u.redisRepo.SetGreaterCtx(ctx, u.generateTOTPStepKey(userTOTP.UserID), at.Unix()/totpPeriod, 3*totpPeriod)
+		if err != nil {
+			return false, errors.Wrap(err, "redisRepo.SetGreaterCtx")
+		}
+		return unused, nil
+	}
+
+	return false, nil
+}
+
+// Create audit event of action on target user, actor is the authenticated user or the target itself
+func (u *userUC) newAuditEvent(ctx context.Context, action string, targetID uuid.UUID, changes models.AuditChanges) *models.AuditEvent {
+	actorID := targetID
+	if session, err := utils.GetSessionFromCtx(ctx); err == nil {
+		actorID = session.UserID
+	}
+
+	return &models.AuditEvent{
+		ActorID:   actorID,
+		TargetID:  targetID,
+		Action:    action,
+		Changes:   changes,
+		IPAddress: utils.GetClientIP(ctx, u.cfg.Server.TrustedProxies),
+	}
+}
+
+// Create domain event of user in outbox, it is committed or rolled back together with the transaction of context
+func (u *userUC) createEvent(ctx context.Context, eventType string, user *models.User, changes models.AuditChanges) error {
+	payload, err := json.Marshal(&models.UserEventPayload{
+		UserID:  user.UserID,
+		Email:   user.Email,
+		Role:    user.GetRole(),
+		Changes: changes,
+	})
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal")
+	}
+
+	return u.outboxRepo.Create(ctx, &models.OutboxEvent{AggregateID: user.UserID, Type: eventType, Payload: payload})
+}
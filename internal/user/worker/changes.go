@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/JamesHsu333/go-grpc/config"
+	"github.com/JamesHsu333/go-grpc/internal/user"
+	"github.com/JamesHsu333/go-grpc/pkg/logger"
+)
+
+// Changes cleanup worker, deletes user changes older than watch retention
+type ChangesCleanupWorker struct {
+	userUC user.UseCase
+	cfg    *config.Config
+	logger logger.Logger
+}
+
+// Changes cleanup worker constructor
+func NewChangesCleanupWorker(userUC user.UseCase, cfg *config.Config, logger logger.Logger) *ChangesCleanupWorker {
+	return &ChangesCleanupWorker{userUC: userUC, cfg: cfg, logger: logger}
+}
+
+// Run cleanup every interval until context is done, changes are kept forever when interval or retention is not set
+func (w *ChangesCleanupWorker) Run(ctx context.Context) {
+	if w.cfg.Watch.CleanupInterval <= 0 || w.cfg.Watch.Retention <= 0 {
+		w.logger.Info("ChangesCleanupWorker: disabled")
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(w.cfg.Watch.CleanupInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			before := time.Now().Add(-time.Duration(w.cfg.Watch.Retention) * time.Second)
+			purged, err := w.userUC.PurgeChanges(ctx, before)
+			if err != nil {
+				w.logger.Errorf("ChangesCleanupWorker.PurgeChanges: %v", err)
+				continue
+			}
+			if purged > 0 {
+				w.logger.Infof("ChangesCleanupWorker: purged %d user changes before %s", purged, before.Format(time.RFC3339))
+			}
+		}
+	}
+}
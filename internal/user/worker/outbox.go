@@ -0,0 +1,154 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/JamesHsu333/go-grpc/config"
+	"github.com/JamesHsu333/go-grpc/internal/models"
+	"github.com/JamesHsu333/go-grpc/internal/user"
+	"github.com/JamesHsu333/go-grpc/pkg/logger"
+	"github.com/JamesHsu333/go-grpc/pkg/metric"
+)
+
+// Outbox relay, publishes outbox events and retries failed ones with exponential backoff.
+// Events failing MaxAttempts times are marked failed and hold back later events of their aggregate
+type OutboxRelay struct {
+	outboxRepo user.OutboxRepository
+	publisher  user.EventPublisher
+	cfg        *config.Config
+	metr       metric.Metrics
+	logger     logger.Logger
+}
+
+// Outbox relay constructor
+func NewOutboxRelay(outboxRepo user.OutboxRepository, publisher user.EventPublisher, cfg *config.Config, metr metric.Metrics, logger logger.Logger) *OutboxRelay {
+	return &OutboxRelay{outboxRepo: outboxRepo, publisher: publisher, cfg: cfg, metr: metr, logger: logger}
+}
+
+// Relay events every poll interval until context is done, full batches are followed without waiting.
+// Published events older than retention are deleted every cleanup interval, they are kept forever when either is not set
+func (r *OutboxRelay) Run(ctx context.Context) {
+	if r.cfg.Outbox.PollInterval <= 0 {
+		r.logger.Info("OutboxRelay: disabled")
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(r.cfg.Outbox.PollInterval) * time.Second)
+	defer ticker.Stop()
+
+	var cleanup <-chan time.Time
+	if r.cfg.Outbox.Retention > 0 && r.cfg.Outbox.CleanupInterval > 0 {
+		cleanupTicker := time.NewTicker(time.Duration(r.cfg.Outbox.CleanupInterval) * time.Second)
+		defer cleanupTicker.Stop()
+		cleanup = cleanupTicker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-cleanup:
+			r.deletePublished(ctx)
+		case <-ticker.C:
+			for {
+				relayed, err := r.relayBatch(ctx)
+				if err != nil {
+					r.logger.Errorf("OutboxRelay.relayBatch: %v", err)
+					break
+				}
+				if relayed < r.cfg.Outbox.BatchSize || ctx.Err() != nil {
+					break
+				}
+			}
+		}
+	}
+}
+
+// Publish batch of due events, events stay locked for other relays until batch bookkeeping is committed
+func (r *OutboxRelay) relayBatch(ctx context.Context) (int, error) {
+	relayed := 0
+	err := r.outboxRepo.WithinTransaction(ctx, func(ctx context.Context) error {
+		events, err := r.outboxRepo.LockPending(ctx, r.cfg.Outbox.BatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			// Attempts may be used up already when max attempts was lowered
+			if r.attemptsExhausted(event.Attempts) {
+				if err = r.markDeadLetter(ctx, event, event.Attempts, event.LastError); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err = r.publisher.Publish(ctx, event); err != nil {
+				r.logger.Errorf("OutboxRelay.Publish: event %s attempt %d: %v", event.EventID, event.Attempts+1, err)
+				if r.attemptsExhausted(event.Attempts + 1) {
+					if err = r.markDeadLetter(ctx, event, event.Attempts+1, err.Error()); err != nil {
+						return err
+					}
+					continue
+				}
+				if err = r.outboxRepo.MarkFailed(ctx, event.EventID, err.Error(), time.Now().Add(r.retryDelay(event.Attempts))); err != nil {
+					return err
+				}
+				continue
+			}
+			if err = r.outboxRepo.MarkPublished(ctx, event.EventID); err != nil {
+				return err
+			}
+		}
+		relayed = len(events)
+
+		return nil
+	})
+
+	return relayed, err
+}
+
+// Attempts are never exhausted when max attempts is not set
+func (r *OutboxRelay) attemptsExhausted(attempts int) bool {
+	return r.cfg.Outbox.MaxAttempts > 0 && attempts >= r.cfg.Outbox.MaxAttempts
+}
+
+// Mark event failed for good and alert, later events of its aggregate are held back until it is resolved
+func (r *OutboxRelay) markDeadLetter(ctx context.Context, event *models.OutboxEvent, attempts int, lastError string) error {
+	if err := r.outboxRepo.MarkDeadLetter(ctx, event.EventID, attempts, lastError); err != nil {
+		return err
+	}
+
+	r.logger.Errorf("OutboxRelay: event %s %s of aggregate %s failed after %d attempts, later events of aggregate are held back: %s",
+		event.EventID, event.Type, event.AggregateID, attempts, lastError)
+	r.metr.IncOutboxDeadLetters(event.Type)
+
+	return nil
+}
+
+// Delete events published before retention period
+func (r *OutboxRelay) deletePublished(ctx context.Context) {
+	before := time.Now().Add(-time.Duration(r.cfg.Outbox.Retention) * time.Second)
+	deleted, err := r.outboxRepo.DeletePublishedBefore(ctx, before)
+	if err != nil {
+		r.logger.Errorf("OutboxRelay.DeletePublishedBefore: %v", err)
+		return
+	}
+	if deleted > 0 {
+		r.logger.Infof("OutboxRelay: deleted %d events published before %s", deleted, before.Format(time.RFC3339))
+	}
+}
+
+// Delay before next attempt, doubles with every failed attempt up to max delay
+func (r *OutboxRelay) retryDelay(attempts int) time.Duration {
+	delay := time.Duration(r.cfg.Outbox.RetryDelay) * time.Second
+	maxDelay := time.Duration(r.cfg.Outbox.MaxDelay) * time.Second
+	for i := 0; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+
+	return delay
+}
@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/JamesHsu333/go-grpc/config"
+	"github.com/JamesHsu333/go-grpc/internal/user"
+	"github.com/JamesHsu333/go-grpc/pkg/logger"
+)
+
+// Purge worker, hard deletes users soft deleted longer than retention period
+type PurgeWorker struct {
+	userUC user.UseCase
+	cfg    *config.Config
+	logger logger.Logger
+}
+
+// Purge worker constructor
+func NewPurgeWorker(userUC user.UseCase, cfg *config.Config, logger logger.Logger) *PurgeWorker {
+	return &PurgeWorker{userUC: userUC, cfg: cfg, logger: logger}
+}
+
+// Run purge every interval until context is done, disabled when interval is not set
+func (w *PurgeWorker) Run(ctx context.Context) {
+	if w.cfg.SoftDelete.PurgeInterval <= 0 {
+		w.logger.Info("PurgeWorker: disabled")
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(w.cfg.SoftDelete.PurgeInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			before := time.Now().Add(-time.Duration(w.cfg.SoftDelete.Retention) * time.Second)
+			purged, err := w.userUC.PurgeDeleted(ctx, before)
+			if err != nil {
+				w.logger.Errorf("PurgeWorker.PurgeDeleted: %v", err)
+				continue
+			}
+			if purged > 0 {
+				w.logger.Infof("PurgeWorker: purged %d users deleted before %s", purged, before.Format(time.RFC3339))
+			}
+		}
+	}
+}
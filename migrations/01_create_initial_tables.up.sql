@@ -1,5 +1,3 @@
-DROP TABLE IF EXISTS users CASCADE;
-
 CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
 CREATE EXTENSION IF NOT EXISTS CITEXT;
 -- CREATE EXTENSION IF NOT EXISTS postgis;
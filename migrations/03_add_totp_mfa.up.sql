@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS user_totp
+(
+    user_id      UUID PRIMARY KEY REFERENCES users (user_id) ON DELETE CASCADE,
+    secret       VARCHAR(64)              NOT NULL CHECK ( secret <> '' ),
+    enabled      BOOLEAN                  NOT NULL DEFAULT FALSE,
+    created_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    confirmed_at TIMESTAMP WITH TIME ZONE          DEFAULT NULL
+);
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes
+(
+    user_id    UUID                     NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    code_hash  VARCHAR(64)              NOT NULL,
+    used_at    TIMESTAMP WITH TIME ZONE          DEFAULT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, code_hash)
+);
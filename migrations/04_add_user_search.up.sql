@@ -0,0 +1,22 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Weighted document searched by SearchUsers, must stay in sync with search queries
+CREATE OR REPLACE FUNCTION users_search_document(first_name TEXT, last_name TEXT, email TEXT, city TEXT, about TEXT)
+    RETURNS tsvector
+    LANGUAGE SQL
+    IMMUTABLE
+AS
+$$
+SELECT setweight(to_tsvector('simple', COALESCE(first_name, '') || ' ' || COALESCE(last_name, '')), 'A') ||
+       setweight(to_tsvector('simple', COALESCE(email, '')), 'B') ||
+       setweight(to_tsvector('simple', COALESCE(city, '')), 'C') ||
+       setweight(to_tsvector('english', COALESCE(about, '')), 'D')
+$$;
+
+CREATE INDEX IF NOT EXISTS users_search_document_idx ON users
+    USING GIN (users_search_document(first_name, last_name, email, city, about));
+
+CREATE INDEX IF NOT EXISTS users_full_name_trgm_idx ON users
+    USING GIN ((first_name || ' ' || last_name) gin_trgm_ops);
+
+CREATE INDEX IF NOT EXISTS users_email_trgm_idx ON users USING GIN (email gin_trgm_ops);
@@ -0,0 +1,12 @@
+DO
+$$
+    BEGIN
+        IF EXISTS(SELECT 1 FROM users WHERE deleted_at IS NOT NULL) THEN
+            RAISE EXCEPTION 'users has soft deleted rows, restore or purge them before reverting';
+        END IF;
+    END
+$$;
+
+DROP INDEX IF EXISTS users_deleted_at_idx;
+
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
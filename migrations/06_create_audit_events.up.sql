@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS audit_events
+(
+    event_id   UUID PRIMARY KEY                  DEFAULT uuid_generate_v4(),
+    actor_id   UUID                     NOT NULL,
+    target_id  UUID                     NOT NULL,
+    action     VARCHAR(32)              NOT NULL CHECK ( action <> '' ),
+    changes    JSONB                    NOT NULL DEFAULT '{}',
+    ip_address VARCHAR(64)              NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_created_at_idx ON audit_events (created_at DESC);
+CREATE INDEX IF NOT EXISTS audit_events_actor_id_idx ON audit_events (actor_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS audit_events_target_id_idx ON audit_events (target_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS audit_events_action_idx ON audit_events (action, created_at DESC);
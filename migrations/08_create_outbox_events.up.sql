@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS outbox_events
+(
+    event_id        UUID PRIMARY KEY                  DEFAULT uuid_generate_v4(),
+    aggregate_id    UUID                     NOT NULL,
+    event_type      VARCHAR(64)              NOT NULL CHECK ( event_type <> '' ),
+    payload         JSONB                    NOT NULL DEFAULT '{}',
+    created_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    published_at    TIMESTAMP WITH TIME ZONE,
+    attempts        INTEGER                  NOT NULL DEFAULT 0,
+    last_error      TEXT                     NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS outbox_events_pending_idx ON outbox_events (next_attempt_at, created_at) WHERE published_at IS NULL;
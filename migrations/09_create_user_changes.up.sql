@@ -0,0 +1,54 @@
+CREATE TABLE IF NOT EXISTS user_changes
+(
+    change_id   BIGSERIAL PRIMARY KEY,
+    txid        BIGINT                   NOT NULL DEFAULT txid_current(),
+    user_id     UUID                     NOT NULL,
+    change_type VARCHAR(16)              NOT NULL CHECK ( change_type <> '' ),
+    changed_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS user_changes_txid_idx ON user_changes (txid, change_id);
+CREATE INDEX IF NOT EXISTS user_changes_changed_at_idx ON user_changes (changed_at);
+
+-- Record user change and notify watchers, changes of soft deleted users are not recorded
+CREATE OR REPLACE FUNCTION record_user_change() RETURNS TRIGGER AS
+$$
+DECLARE
+    v_type    TEXT;
+    v_user_id UUID;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        v_type := 'created';
+        v_user_id := NEW.user_id;
+    ELSIF TG_OP = 'UPDATE' THEN
+        IF NEW IS NOT DISTINCT FROM OLD THEN
+            RETURN NULL;
+        END IF;
+        IF NEW.deleted_at IS NOT NULL AND OLD.deleted_at IS NULL THEN
+            v_type := 'deleted';
+        ELSIF NEW.deleted_at IS NOT NULL THEN
+            RETURN NULL;
+        ELSE
+            v_type := 'updated';
+        END IF;
+        v_user_id := NEW.user_id;
+    ELSE
+        IF OLD.deleted_at IS NOT NULL THEN
+            RETURN NULL;
+        END IF;
+        v_type := 'deleted';
+        v_user_id := OLD.user_id;
+    END IF;
+
+    INSERT INTO user_changes (user_id, change_type) VALUES (v_user_id, v_type);
+    PERFORM pg_notify('user_changes', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_record_change ON users;
+CREATE TRIGGER users_record_change
+    AFTER INSERT OR UPDATE OR DELETE
+    ON users
+    FOR EACH ROW
+EXECUTE PROCEDURE record_user_change();
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS outbox_events_published_at_idx;
+DROP INDEX IF EXISTS outbox_events_aggregate_idx;
+DROP INDEX IF EXISTS outbox_events_pending_idx;
+
+ALTER TABLE outbox_events DROP COLUMN IF EXISTS failed_at;
+
+CREATE INDEX IF NOT EXISTS outbox_events_pending_idx ON outbox_events (next_attempt_at, created_at) WHERE published_at IS NULL;
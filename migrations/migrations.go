@@ -0,0 +1,7 @@
+// Package migrations embeds sql migrations, files are named <version>_<name>.<up|down>.sql
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,330 @@
+package postgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/JamesHsu333/go-grpc/pkg/logger"
+)
+
+const (
+	// Advisory lock held while migrating, replicas starting together wait for each other
+	migrationLockKey = 4358234651207
+
+	createVersionsTableQuery = `CREATE TABLE IF NOT EXISTS schema_versions
+								(
+									version    INTEGER PRIMARY KEY,
+									name       TEXT                     NOT NULL,
+									checksum   TEXT                     NOT NULL,
+									applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+								)`
+
+	lockMigrationsQuery = `SELECT pg_advisory_lock($1)`
+
+	unlockMigrationsQuery = `SELECT pg_advisory_unlock($1)`
+
+	getAppliedVersionsQuery = `SELECT version, checksum FROM schema_versions ORDER BY version`
+
+	insertVersionQuery = `INSERT INTO schema_versions (version, name, checksum) VALUES ($1, $2, $3)`
+
+	deleteVersionQuery = `DELETE FROM schema_versions WHERE version = $1`
+
+	// Version table of migrate cli used before migrations were embedded
+	getLegacyVersionQuery = `SELECT version, dirty FROM schema_migrations LIMIT 1`
+
+	legacyVersionsTableExistsQuery = `SELECT to_regclass('schema_migrations') IS NOT NULL`
+)
+
+var (
+	ErrChecksumMismatch = errors.New("Migration checksum mismatch")
+	ErrUnknownMigration = errors.New("Applied migration is unknown")
+	ErrDirtyMigration   = errors.New("Legacy migration is dirty")
+	ErrInvalidSteps     = errors.New("Migration steps must be positive")
+)
+
+var migrationFileRegexp = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration of one version, checksum is computed from up sql
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Applied migration
+type appliedVersion struct {
+	Version  int    `db:"version"`
+	Checksum string `db:"checksum"`
+}
+
+// Migrator applies migrations in version order, applied versions are kept in schema_versions table
+type Migrator struct {
+	db         *sqlx.DB
+	migrations []*Migration
+	logger     logger.Logger
+}
+
+// Migrator constructor, reads <version>_<name>.<up|down>.sql files of fsys root
+func NewMigrator(db *sqlx.DB, fsys fs.FS, logger logger.Logger) (*Migrator, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, errors.Wrap(err, "fs.ReadDir")
+	}
+
+	byVersion := make(map[int]*Migration, len(entries))
+	for _, entry := range entries {
+		match := migrationFileRegexp.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "migration %s", entry.Name())
+		}
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, errors.Wrapf(err, "migration %s", entry.Name())
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, errors.Errorf("migration %d has files with different names", version)
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+			checksum := sha256.Sum256(content)
+			migration.Checksum = hex.EncodeToString(checksum[:])
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Checksum == "" {
+			return nil, errors.Errorf("migration %d has no up file", migration.Version)
+		}
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return &Migrator{db: db, migrations: migrations, logger: logger}, nil
+}
+
+// Apply pending migrations, all of them when steps is not positive
+func (m *Migrator) Up(ctx context.Context, steps int) error {
+	return m.withLock(ctx, func(conn *sqlx.Conn, applied map[int]string) error {
+		pending := make([]*Migration, 0, len(m.migrations))
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; !ok {
+				pending = append(pending, migration)
+			}
+		}
+		if steps > 0 && steps < len(pending) {
+			pending = pending[:steps]
+		}
+
+		for _, migration := range pending {
+			if err := m.apply(ctx, conn, migration.Up, insertVersionQuery, migration.Version, migration.Name, migration.Checksum); err != nil {
+				return errors.Wrapf(err, "migration %d_%s up", migration.Version, migration.Name)
+			}
+			m.logger.Infof("Migration %d_%s applied", migration.Version, migration.Name)
+		}
+		if len(pending) == 0 {
+			m.logger.Info("No pending migrations")
+		}
+
+		return nil
+	})
+}
+
+// Revert given number of applied migrations newest first
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	if steps <= 0 {
+		return errors.Wrapf(ErrInvalidSteps, "steps %d", steps)
+	}
+	return m.down(ctx, steps)
+}
+
+// Revert every applied migration, drops all tables
+func (m *Migrator) DownAll(ctx context.Context) error {
+	return m.down(ctx, len(m.migrations))
+}
+
+func (m *Migrator) down(ctx context.Context, steps int) error {
+	return m.withLock(ctx, func(conn *sqlx.Conn, applied map[int]string) error {
+		reverted := 0
+		for i := len(m.migrations) - 1; i >= 0 && reverted < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+
+			if err := m.apply(ctx, conn, migration.Down, deleteVersionQuery, migration.Version); err != nil {
+				return errors.Wrapf(err, "migration %d_%s down", migration.Version, migration.Name)
+			}
+			m.logger.Infof("Migration %d_%s reverted", migration.Version, migration.Name)
+			reverted++
+		}
+
+		return nil
+	})
+}
+
+// Get latest applied version, zero when none is applied
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	version := 0
+	err := m.withLock(ctx, func(conn *sqlx.Conn, applied map[int]string) error {
+		for v := range applied {
+			if v > version {
+				version = v
+			}
+		}
+		return nil
+	})
+
+	return version, err
+}
+
+// Run migration sql and record version change in one transaction
+func (m *Migrator) apply(ctx context.Context, conn *sqlx.Conn, query string, versionQuery string, args ...interface{}) (err error) {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "BeginTxx")
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, query); err != nil {
+		return errors.Wrap(err, "ExecContext")
+	}
+	if _, err = tx.ExecContext(ctx, versionQuery, args...); err != nil {
+		return errors.Wrap(err, "ExecContext version")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "Commit")
+	}
+
+	return nil
+}
+
+// Run fn on a single connection holding migration lock, applied migrations are verified against known ones first
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sqlx.Conn, applied map[int]string) error) error {
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Migrator.Connx")
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, lockMigrationsQuery, migrationLockKey); err != nil {
+		return errors.Wrap(err, "Migrator.lock")
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), unlockMigrationsQuery, migrationLockKey); err != nil {
+			m.logger.Errorf("Migrator.unlock: %v", err)
+		}
+	}()
+
+	if _, err = conn.ExecContext(ctx, createVersionsTableQuery); err != nil {
+		return errors.Wrap(err, "Migrator.createVersionsTable")
+	}
+
+	versions := make([]appliedVersion, 0)
+	if err = sqlx.SelectContext(ctx, conn, &versions, getAppliedVersionsQuery); err != nil {
+		return errors.Wrap(err, "Migrator.getAppliedVersions")
+	}
+	if len(versions) == 0 {
+		if versions, err = m.adoptLegacyVersion(ctx, conn); err != nil {
+			return errors.Wrap(err, "Migrator.adoptLegacyVersion")
+		}
+	}
+
+	applied := make(map[int]string, len(versions))
+	for _, version := range versions {
+		applied[version.Version] = version.Checksum
+	}
+	if err = m.verify(applied); err != nil {
+		return err
+	}
+
+	return fn(conn, applied)
+}
+
+// Every applied migration must be known and unchanged since it was applied
+func (m *Migrator) verify(applied map[int]string) error {
+	known := make(map[int]*Migration, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+
+	for version, checksum := range applied {
+		migration, ok := known[version]
+		if !ok {
+			return errors.Wrapf(ErrUnknownMigration, "version %d", version)
+		}
+		if migration.Checksum != checksum {
+			return errors.Wrapf(ErrChecksumMismatch, "migration %d_%s", migration.Version, migration.Name)
+		}
+	}
+
+	return nil
+}
+
+// Record migrations applied by migrate cli as applied, so they are not run again
+func (m *Migrator) adoptLegacyVersion(ctx context.Context, conn *sqlx.Conn) ([]appliedVersion, error) {
+	var exists bool
+	if err := conn.QueryRowxContext(ctx, legacyVersionsTableExistsQuery).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	var legacyVersion int
+	var dirty bool
+	if err := conn.QueryRowxContext(ctx, getLegacyVersionQuery).Scan(&legacyVersion, &dirty); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if dirty {
+		return nil, errors.Wrapf(ErrDirtyMigration, "version %d", legacyVersion)
+	}
+
+	versions := make([]appliedVersion, 0, legacyVersion)
+	for _, migration := range m.migrations {
+		if migration.Version > legacyVersion {
+			break
+		}
+		if _, err := conn.ExecContext(ctx, insertVersionQuery, migration.Version, migration.Name, migration.Checksum); err != nil {
+			return nil, err
+		}
+		versions = append(versions, appliedVersion{Version: migration.Version, Checksum: migration.Checksum})
+	}
+	m.logger.Infof("Adopted migrations up to version %d applied by migrate cli", legacyVersion)
+
+	return versions, nil
+}
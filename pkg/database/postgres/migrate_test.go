@@ -0,0 +1,165 @@
+package postgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"testing/fstest"
+
+	"github.com/pkg/errors"
+)
+
+func checksumOf(content string) string {
+	checksum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(checksum[:])
+}
+
+func TestNewMigrator(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		want    []Migration
+		wantErr bool
+	}{
+		{
+			name: "sorted by version",
+			files: fstest.MapFS{
+				"10_add_index.up.sql":      {Data: []byte("CREATE INDEX")},
+				"02_add_column.up.sql":     {Data: []byte("ALTER TABLE")},
+				"02_add_column.down.sql":   {Data: []byte("ALTER TABLE DROP")},
+				"01_create_users.up.sql":   {Data: []byte("CREATE TABLE")},
+				"01_create_users.down.sql": {Data: []byte("DROP TABLE")},
+			},
+			want: []Migration{
+				{Version: 1, Name: "create_users", Up: "CREATE TABLE", Down: "DROP TABLE", Checksum: checksumOf("CREATE TABLE")},
+				{Version: 2, Name: "add_column", Up: "ALTER TABLE", Down: "ALTER TABLE DROP", Checksum: checksumOf("ALTER TABLE")},
+				{Version: 10, Name: "add_index", Up: "CREATE INDEX", Checksum: checksumOf("CREATE INDEX")},
+			},
+		},
+		{
+			name: "other files are ignored",
+			files: fstest.MapFS{
+				"01_create_users.up.sql": {Data: []byte("CREATE TABLE")},
+				"migrations.go":          {Data: []byte("package migrations")},
+				"README.md":              {Data: []byte("readme")},
+				"create_users.up.sql":    {Data: []byte("CREATE TABLE")},
+				"01_create_users.sql":    {Data: []byte("CREATE TABLE")},
+				"02.up.sql":              {Data: []byte("CREATE TABLE")},
+			},
+			want: []Migration{
+				{Version: 1, Name: "create_users", Up: "CREATE TABLE", Checksum: checksumOf("CREATE TABLE")},
+			},
+		},
+		{
+			name:  "empty",
+			files: fstest.MapFS{},
+			want:  []Migration{},
+		},
+		{
+			name: "different names of one version",
+			files: fstest.MapFS{
+				"01_create_users.up.sql":    {Data: []byte("CREATE TABLE")},
+				"01_create_people.down.sql": {Data: []byte("DROP TABLE")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "down without up",
+			files: fstest.MapFS{
+				"01_create_users.down.sql": {Data: []byte("DROP TABLE")},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrator, err := NewMigrator(nil, tt.files, nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("NewMigrator() error = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewMigrator() unexpected error: %v", err)
+			}
+
+			if len(migrator.migrations) != len(tt.want) {
+				t.Fatalf("NewMigrator() got %d migrations, want %d", len(migrator.migrations), len(tt.want))
+			}
+			for i, migration := range migrator.migrations {
+				if *migration != tt.want[i] {
+					t.Errorf("migration %d = %+v, want %+v", i, *migration, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMigratorVerify(t *testing.T) {
+	migrator, err := NewMigrator(nil, fstest.MapFS{
+		"01_create_users.up.sql": {Data: []byte("CREATE TABLE")},
+		"02_add_column.up.sql":   {Data: []byte("ALTER TABLE")},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewMigrator() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		applied map[int]string
+		wantErr error
+	}{
+		{
+			name:    "none applied",
+			applied: map[int]string{},
+		},
+		{
+			name:    "applied unchanged",
+			applied: map[int]string{1: checksumOf("CREATE TABLE"), 2: checksumOf("ALTER TABLE")},
+		},
+		{
+			name:    "checksum mismatch",
+			applied: map[int]string{1: checksumOf("CREATE TABLE users")},
+			wantErr: ErrChecksumMismatch,
+		},
+		{
+			name:    "unknown version",
+			applied: map[int]string{1: checksumOf("CREATE TABLE"), 3: checksumOf("CREATE INDEX")},
+			wantErr: ErrUnknownMigration,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := migrator.verify(tt.applied)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("verify() unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMigratorDownInvalidSteps(t *testing.T) {
+	migrator, err := NewMigrator(nil, fstest.MapFS{
+		"01_create_users.up.sql":   {Data: []byte("CREATE TABLE")},
+		"01_create_users.down.sql": {Data: []byte("DROP TABLE")},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewMigrator() unexpected error: %v", err)
+	}
+
+	for _, steps := range []int{0, -1} {
+		if err := migrator.Down(context.Background(), steps); !errors.Is(err, ErrInvalidSteps) {
+			t.Errorf("Down(%d) error = %v, want %v", steps, err, ErrInvalidSteps)
+		}
+	}
+}
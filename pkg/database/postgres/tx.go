@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Query executor implemented by both *sqlx.DB and *sqlx.Tx
+type Executor interface {
+	sqlx.ExtContext
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+}
+
+type txCtxKey struct{}
+
+// Run fn within transaction, repositories getting executor by Conn share it; nested calls join outer transaction
+func WithinTransaction(ctx context.Context, db *sqlx.DB, fn func(ctx context.Context) error) (err error) {
+	if _, ok := ctx.Value(txCtxKey{}).(*sqlx.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "postgres.WithinTransaction.BeginTxx")
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err = fn(context.WithValue(ctx, txCtxKey{}, tx)); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "postgres.WithinTransaction.Commit")
+	}
+
+	return nil
+}
+
+// Get transaction of context if any, otherwise db
+func Conn(ctx context.Context, db *sqlx.DB) Executor {
+	if tx, ok := ctx.Value(txCtxKey{}).(*sqlx.Tx); ok {
+		return tx
+	}
+	return db
+}
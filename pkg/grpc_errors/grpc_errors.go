@@ -3,12 +3,18 @@ package grpc_errors
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 var (
@@ -16,11 +22,88 @@ var (
 	ErrNoCtxMetaData    = errors.New("No ctx metadata")
 	ErrInvalidSessionId = errors.New("Invalid session id")
 	ErrEmailExists      = errors.New("Email already exists")
+	ErrUnauthenticated  = errors.New("Unauthenticated")
+	ErrPermissionDenied = errors.New("Permission denied")
+	ErrFileTooLarge     = errors.New("File too large")
+	ErrNotAllowedImage  = errors.New("Not allowed image content type")
+	ErrInvalidToken     = errors.New("Invalid token")
+	ErrTokenReused      = errors.New("Refresh token reused")
+	ErrWrongPassword    = errors.New("Wrong password")
+	ErrEmailNotVerified = errors.New("Email not verified")
+	ErrInvalidLogin     = errors.New("Invalid email or password")
+	ErrAccountLocked    = errors.New("Account temporarily locked")
+	ErrMFAEnabled       = errors.New("MFA already enabled")
+	ErrMFANotEnabled    = errors.New("MFA not enabled")
+	ErrInvalidMFACode   = errors.New("Invalid MFA code")
+	ErrInvalidPageToken = errors.New("Invalid page token")
+	ErrInvalidSort      = errors.New("Invalid sort")
+	ErrVersionConflict  = errors.New("Version conflict")
+	ErrInvalidMask      = errors.New("Invalid update mask")
+	ErrBatchTooLarge    = errors.New("Too many ids in batch")
+	ErrInvalidResume    = errors.New("Invalid resume token")
+	ErrResumeExpired    = errors.New("Resume token expired")
+	ErrInvalidChange    = errors.New("Invalid change type")
+	ErrInvalidObject    = errors.New("Invalid object name")
 )
 
+// Account lockout error, RetryAfter is time left until lock expires
+type LockoutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", ErrAccountLocked, e.RetryAfter)
+}
+
+func (e *LockoutError) Unwrap() error {
+	return ErrAccountLocked
+}
+
+// Optimistic concurrency error, Current is the version stored when the write was rejected
+type VersionConflictError struct {
+	Expected int64
+	Current  int64
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("%v, expected version %d, current version %d", ErrVersionConflict, e.Expected, e.Current)
+}
+
+func (e *VersionConflictError) Unwrap() error {
+	return ErrVersionConflict
+}
+
+// Create grpc status of error, lockout and version conflict errors carry details for clients
+func ErrorStatus(err error, format string, a ...interface{}) *status.Status {
+	st := status.Newf(ParseGRPCErrStatusCode(err), format, a...)
+
+	var lockoutErr *LockoutError
+	var conflictErr *VersionConflictError
+	var detailed *status.Status
+	var detailErr error
+	switch {
+	case errors.As(err, &lockoutErr):
+		detailed, detailErr = st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(lockoutErr.RetryAfter)})
+	case errors.As(err, &conflictErr):
+		detailed, detailErr = st.WithDetails(&errdetails.ErrorInfo{
+			Reason:   "VERSION_CONFLICT",
+			Metadata: map[string]string{"current_version": strconv.FormatInt(conflictErr.Current, 10)},
+		})
+	default:
+		return st
+	}
+	if detailErr != nil {
+		return st
+	}
+
+	return detailed
+}
+
 // Parse error and get code
 func ParseGRPCErrStatusCode(err error) codes.Code {
 	switch {
+	case errors.Is(err, ErrNotFound):
+		return codes.NotFound
 	case errors.Is(err, sql.ErrNoRows):
 		return codes.NotFound
 	case errors.Is(err, redis.Nil):
@@ -33,8 +116,50 @@ func ParseGRPCErrStatusCode(err error) codes.Code {
 		return codes.AlreadyExists
 	case errors.Is(err, ErrNoCtxMetaData):
 		return codes.Unauthenticated
+	case errors.Is(err, ErrUnauthenticated):
+		return codes.Unauthenticated
 	case errors.Is(err, ErrInvalidSessionId):
 		return codes.PermissionDenied
+	case errors.Is(err, ErrPermissionDenied):
+		return codes.PermissionDenied
+	case errors.Is(err, ErrInvalidToken):
+		return codes.Unauthenticated
+	case errors.Is(err, ErrTokenReused):
+		return codes.Unauthenticated
+	case errors.Is(err, ErrWrongPassword):
+		return codes.PermissionDenied
+	case errors.Is(err, ErrEmailNotVerified):
+		return codes.FailedPrecondition
+	case errors.Is(err, ErrInvalidLogin):
+		return codes.Unauthenticated
+	case errors.Is(err, ErrAccountLocked):
+		return codes.ResourceExhausted
+	case errors.Is(err, ErrMFAEnabled):
+		return codes.FailedPrecondition
+	case errors.Is(err, ErrMFANotEnabled):
+		return codes.FailedPrecondition
+	case errors.Is(err, ErrInvalidMFACode):
+		return codes.Unauthenticated
+	case errors.Is(err, ErrInvalidPageToken):
+		return codes.InvalidArgument
+	case errors.Is(err, ErrInvalidSort):
+		return codes.InvalidArgument
+	case errors.Is(err, ErrVersionConflict):
+		return codes.Aborted
+	case errors.Is(err, ErrInvalidMask):
+		return codes.InvalidArgument
+	case errors.Is(err, ErrBatchTooLarge):
+		return codes.InvalidArgument
+	case errors.Is(err, ErrInvalidResume):
+		return codes.InvalidArgument
+	case errors.Is(err, ErrResumeExpired):
+		return codes.FailedPrecondition
+	case errors.Is(err, ErrInvalidChange):
+		return codes.InvalidArgument
+	case errors.Is(err, ErrFileTooLarge):
+		return codes.ResourceExhausted
+	case errors.Is(err, ErrNotAllowedImage):
+		return codes.InvalidArgument
 	case strings.Contains(err.Error(), "Validate"):
 		return codes.InvalidArgument
 	case strings.Contains(err.Error(), "redis"):
@@ -62,6 +187,12 @@ func MapGRPCErrCodeToHttpStatus(code codes.Code) int {
 		return http.StatusGatewayTimeout
 	case codes.InvalidArgument:
 		return http.StatusBadRequest
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.FailedPrecondition:
+		return http.StatusPreconditionFailed
+	case codes.Aborted:
+		return http.StatusConflict
 	}
 	return http.StatusInternalServerError
 }
@@ -14,6 +14,9 @@ import (
 type Metrics interface {
 	IncHits(status int, method, path string)
 	ObserveResponseTime(status int, method, path string, observeTime float64)
+	IncLockouts(kind string)
+	IncCacheRequests(cache, result string)
+	IncOutboxDeadLetters(eventType string)
 }
 
 // Prometheus Metrics struct
@@ -21,6 +24,9 @@ type PrometheusMetrics struct {
 	HitsTotal prometheus.Counter
 	Hits      *prometheus.CounterVec
 	Times     *prometheus.HistogramVec
+	Lockouts  *prometheus.CounterVec
+	Cache     *prometheus.CounterVec
+	Outbox    *prometheus.CounterVec
 }
 
 // Create metrics with address and name
@@ -56,6 +62,39 @@ func CreateMetrics(address string, name string) (Metrics, error) {
 		return nil, err
 	}
 
+	metr.Lockouts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: name + "_lockouts_total",
+		},
+		[]string{"kind"},
+	)
+
+	if err := prometheus.Register(metr.Lockouts); err != nil {
+		return nil, err
+	}
+
+	metr.Cache = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: name + "_cache_requests_total",
+		},
+		[]string{"cache", "result"},
+	)
+
+	if err := prometheus.Register(metr.Cache); err != nil {
+		return nil, err
+	}
+
+	metr.Outbox = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: name + "_outbox_dead_letters_total",
+		},
+		[]string{"type"},
+	)
+
+	if err := prometheus.Register(metr.Outbox); err != nil {
+		return nil, err
+	}
+
 	if err := prometheus.Register(collectors.NewBuildInfoCollector()); err != nil {
 		return nil, err
 	}
@@ -82,3 +121,18 @@ func (metr *PrometheusMetrics) IncHits(status int, method, path string) {
 func (metr *PrometheusMetrics) ObserveResponseTime(status int, method, path string, observeTime float64) {
 	metr.Times.WithLabelValues(strconv.Itoa(status), method, path).Observe(observeTime)
 }
+
+// Increment login lockouts by kind, e.g. email or ip
+func (metr *PrometheusMetrics) IncLockouts(kind string) {
+	metr.Lockouts.WithLabelValues(kind).Inc()
+}
+
+// Increment cache requests by result, e.g. hit, miss or coalesced
+func (metr *PrometheusMetrics) IncCacheRequests(cache, result string) {
+	metr.Cache.WithLabelValues(cache, result).Inc()
+}
+
+// Increment outbox events failed after their last attempt by event type
+func (metr *PrometheusMetrics) IncOutboxDeadLetters(eventType string) {
+	metr.Outbox.WithLabelValues(eventType).Inc()
+}
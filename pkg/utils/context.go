@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"context"
+
+	"github.com/JamesHsu333/go-grpc/internal/models"
+	"github.com/JamesHsu333/go-grpc/pkg/grpc_errors"
+)
+
+// Session context key
+type SessionCtxKey struct{}
+
+// Put authenticated session into context
+func ContextWithSession(ctx context.Context, sess *models.Session) context.Context {
+	return context.WithValue(ctx, SessionCtxKey{}, sess)
+}
+
+// Get authenticated session from context
+func GetSessionFromCtx(ctx context.Context) (*models.Session, error) {
+	sess, ok := ctx.Value(SessionCtxKey{}).(*models.Session)
+	if !ok || sess == nil {
+		return nil, grpc_errors.ErrUnauthenticated
+	}
+	return sess, nil
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+
+	"github.com/JamesHsu333/go-grpc/config"
+	"github.com/JamesHsu333/go-grpc/internal/models"
+	"github.com/JamesHsu333/go-grpc/pkg/grpc_errors"
+)
+
+// JWT Claims struct
+type Claims struct {
+	UserID    string `json:"user_id"`
+	Role      string `json:"role"`
+	SessionID string `json:"session_id"`
+	jwt.RegisteredClaims
+}
+
+// Generate new access token signed with current key, key id is put into token header
+func GenerateJWTToken(user *models.User, sessionID string, cfg *config.Config) (string, time.Time, error) {
+	secret, ok := getJWTKeys(cfg)[cfg.Jwt.KeyID]
+	if !ok {
+		return "", time.Time{}, errors.Errorf("jwt key %q not found", cfg.Jwt.KeyID)
+	}
+
+	expiresAt := time.Now().Add(time.Duration(cfg.Jwt.AccessTokenExpire) * time.Second)
+	claims := &Claims{
+		UserID:    user.UserID.String(),
+		Role:      user.GetRole(),
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.UserID.String(),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = cfg.Jwt.KeyID
+
+	tokenString, err := token.SignedString([]byte(secret))
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "token.SignedString")
+	}
+
+	return tokenString, expiresAt, nil
+}
+
+// Parse and verify access token, signing key is chosen by key id header
+func ParseJWTToken(tokenString string, cfg *config.Config) (*Claims, error) {
+	keys := getJWTKeys(cfg)
+
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		secret, ok := keys[kid]
+		if !ok {
+			return nil, errors.Errorf("unknown jwt key %q", kid)
+		}
+		return []byte(secret), nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(grpc_errors.ErrInvalidToken, err.Error())
+	}
+	if !token.Valid {
+		return nil, grpc_errors.ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// Get jwt keys by id, server JwtSecretKey is used for current key id if it is not listed
+func getJWTKeys(cfg *config.Config) map[string]string {
+	keys := make(map[string]string, len(cfg.Jwt.Keys)+1)
+	for _, k := range cfg.Jwt.Keys {
+		keys[k.ID] = k.Secret
+	}
+	if _, ok := keys[cfg.Jwt.KeyID]; !ok && cfg.Server.JwtSecretKey != "" {
+		keys[cfg.Jwt.KeyID] = cfg.Server.JwtSecretKey
+	}
+	return keys
+}
@@ -8,13 +8,16 @@ import (
 
 const (
 	defaultSize = 10
+	maxSize     = 100
 )
 
 // Pagination query params
 type PaginationQuery struct {
-	Size    int    `json:"size,omitempty"`
-	Page    int    `json:"page,omitempty"`
-	OrderBy string `json:"orderBy,omitempty"`
+	Size           int    `json:"size,omitempty"`
+	Page           int    `json:"page,omitempty"`
+	OrderBy        string `json:"orderBy,omitempty"`
+	PageToken      string `json:"pageToken,omitempty"`
+	SkipTotalCount bool   `json:"skipTotalCount,omitempty"`
 }
 
 // Set page size
@@ -80,6 +83,27 @@ func (q *PaginationQuery) GetSize() int {
 	return q.Size
 }
 
+// Get page token, takes precedence over page number
+func (q *PaginationQuery) GetPageToken() string {
+	return q.PageToken
+}
+
+// Get skip total count
+func (q *PaginationQuery) GetSkipTotalCount() bool {
+	return q.SkipTotalCount
+}
+
+// Validate page is not negative and size is between 1 and 100
+func (q *PaginationQuery) Validate() error {
+	if q.Page < 0 {
+		return fmt.Errorf("page must not be negative, got %d", q.Page)
+	}
+	if q.Size < 1 || q.Size > maxSize {
+		return fmt.Errorf("size must be between 1 and %d, got %d", maxSize, q.Size)
+	}
+	return nil
+}
+
 func (q *PaginationQuery) GetQueryString() string {
 	return fmt.Sprintf("page=%v&size=%v&orderBy=%s", q.GetPage(), q.GetSize(), q.GetOrderBy())
 }
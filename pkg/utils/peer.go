@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	forwardedForHeader = "x-forwarded-for"
+	userAgentHeader    = "user-agent"
+)
+
+// Get client ip, forwarded metadata is honoured only when sent by one of trusted proxies (ips or cidrs).
+// Forwarded addresses are walked from the nearest hop and the first one not of a trusted proxy is the client
+func GetClientIP(ctx context.Context, trustedProxies []string) string {
+	peerIP := getPeerIP(ctx)
+	if peerIP == "" || !isTrustedProxy(peerIP, trustedProxies) {
+		return peerIP
+	}
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return peerIP
+	}
+
+	hops := make([]string, 0)
+	for _, forwarded := range md.Get(forwardedForHeader) {
+		for _, hop := range strings.Split(forwarded, ",") {
+			if hop = strings.TrimSpace(hop); net.ParseIP(hop) != nil {
+				hops = append(hops, hop)
+			}
+		}
+	}
+
+	clientIP := peerIP
+	for i := len(hops) - 1; i >= 0; i-- {
+		clientIP = hops[i]
+		if !isTrustedProxy(clientIP, trustedProxies) {
+			break
+		}
+	}
+	return clientIP
+}
+
+func getPeerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+func isTrustedProxy(ip string, trustedProxies []string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+
+	for _, proxy := range trustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+			continue
+		}
+		if proxyAddr := net.ParseIP(proxy); proxyAddr != nil && proxyAddr.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get client user agent from metadata
+func GetUserAgent(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	userAgent := md.Get(userAgentHeader)
+	if len(userAgent) == 0 {
+		return ""
+	}
+	return userAgent[0]
+}
@@ -19,9 +19,19 @@ func ValidateStruct(ctx context.Context, s interface{}) error {
 	return validate.StructCtx(ctx, s)
 }
 
+// Validate only given struct fields
+func ValidateStructPartial(ctx context.Context, s interface{}, fields ...string) error {
+	return validate.StructPartialCtx(ctx, s, fields...)
+}
+
 // Validate email address
 func ValidateEmail(email string) bool {
 	var emailRegex = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
 	return emailRegex.MatchString(email)
 }
+
+// Validate password length, bcrypt ignores bytes after 72
+func ValidatePassword(password string) bool {
+	return len(password) >= 6 && len(password) <= 72
+}